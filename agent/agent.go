@@ -186,6 +186,8 @@ func (a *Agent) run(ctx context.Context) {
 	jobsManager.Out = a.Out
 	jobsManager.Modules = enabledModules
 
+	functionsManager.Register(jobmgr.ModuleFunction, jobsManager.ServeModuleFunction)
+
 	// TODO: rm 'if' after https://github.com/netdata/netdata/issues/16079
 	if logger.Level.Enabled(slog.LevelDebug) {
 		dyncfgDiscovery, _ := dyncfg.NewDiscovery(dyncfg.Config{
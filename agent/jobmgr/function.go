@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package jobmgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/netdata/go.d.plugin/agent/functions"
+	"github.com/netdata/go.d.plugin/agent/module"
+	"github.com/netdata/go.d.plugin/agent/netdataapi"
+)
+
+// ModuleFunction is the name of the function that runs running jobs on-demand functions.
+// Arguments: <module> <job> [function arguments...].
+const ModuleFunction = "module_function"
+
+const defaultFunctionTimeout = time.Second * 10
+
+const moduleFunctionHelp = "Runs an on-demand function of a running job. Arguments: <module> <job> [function arguments...]"
+
+// declareFunctions declares the module function to netdata, so it routes the function calls to the plugin.
+func (m *Manager) declareFunctions() {
+	api := netdataapi.New(m.Out)
+	if err := api.FUNCTIONGLOBAL(ModuleFunction, int(defaultFunctionTimeout.Seconds()), moduleFunctionHelp); err != nil {
+		m.Warningf("declare function '%s': %v", ModuleFunction, err)
+	}
+}
+
+// ServeModuleFunction dispatches the function to the running job module if it implements module.FunctionHandler.
+func (m *Manager) ServeModuleFunction(fn functions.Function) {
+	api := netdataapi.New(m.Out)
+
+	if len(fn.Args) < 2 {
+		m.Warningf("function '%s': wrong number of arguments: want at least 2, got %d", fn.Name, len(fn.Args))
+		_ = api.FunctionResultReject(fn.UID, "application/json",
+			jsonError(fmt.Sprintf("wrong number of arguments: want at least 2 (module, job), got %d", len(fn.Args))))
+		return
+	}
+
+	moduleName, jobName := fn.Args[0], fn.Args[1]
+
+	h, runCtx, ok := m.lookupFunctionHandler(moduleName, jobName)
+	if !ok {
+		_ = api.FunctionResultReject(fn.UID, "application/json",
+			jsonError(fmt.Sprintf("%s[%s] job is not running or does not support functions", moduleName, jobName)))
+		return
+	}
+
+	timeout := fn.Timeout
+	if timeout <= 0 {
+		timeout = defaultFunctionTimeout
+	}
+	// the call is cancelled on the timeout and on the plugin shutdown
+	ctx, cancel := context.WithTimeout(runCtx, timeout)
+	defer cancel()
+
+	type result struct {
+		bs  []byte
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		bs, err := h.HandleFunction(ctx, fn.Args[2:])
+		ch <- result{bs: bs, err: err}
+	}()
+
+	var res result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		// the handler may not honor the context, the call is rejected without waiting for it
+		res.err = ctx.Err()
+	}
+
+	if res.err != nil {
+		m.Warningf("%s[%s] function: %v", moduleName, jobName, res.err)
+		_ = api.FunctionResultReject(fn.UID, "application/json", jsonError(res.err.Error()))
+		return
+	}
+
+	_ = api.FunctionResultSuccess(fn.UID, "application/json", string(res.bs))
+}
+
+func (m *Manager) lookupFunctionHandler(moduleName, jobName string) (module.FunctionHandler, context.Context, bool) {
+	m.queueMux.Lock()
+	defer m.queueMux.Unlock()
+
+	for _, job := range m.queue {
+		if job.ModuleName() != moduleName || job.Name() != jobName {
+			continue
+		}
+		j, ok := job.(*module.Job)
+		if !ok {
+			return nil, nil, false
+		}
+		h, ok := j.Module().(module.FunctionHandler)
+		return h, m.ctx, ok
+	}
+
+	return nil, nil, false
+}
+
+func jsonError(msg string) string {
+	bs, _ := json.Marshal(map[string]string{"error": msg})
+	return string(bs)
+}
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package jobmgr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/netdata/go.d.plugin/agent/functions"
+	"github.com/netdata/go.d.plugin/agent/module"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockFunctionModule struct {
+	module.MockModule
+	handle func(ctx context.Context, args []string) ([]byte, error)
+}
+
+func (m *mockFunctionModule) HandleFunction(ctx context.Context, args []string) ([]byte, error) {
+	return m.handle(ctx, args)
+}
+
+func TestManager_declareFunctions(t *testing.T) {
+	var buf bytes.Buffer
+	mgr := NewManager()
+	mgr.Out = &buf
+
+	mgr.declareFunctions()
+
+	assert.Equal(t, "FUNCTION GLOBAL 'module_function' 10 '"+moduleFunctionHelp+"'\n\n", buf.String())
+}
+
+func TestManager_ServeModuleFunction(t *testing.T) {
+	echo := &mockFunctionModule{handle: func(_ context.Context, args []string) ([]byte, error) {
+		return []byte(`{"args":"` + args[0] + `"}`), nil
+	}}
+	failing := &mockFunctionModule{handle: func(context.Context, []string) ([]byte, error) {
+		return nil, errors.New("boom")
+	}}
+	blocking := &mockFunctionModule{handle: func(ctx context.Context, _ []string) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	ignoringCtx := &mockFunctionModule{handle: func(context.Context, []string) ([]byte, error) {
+		time.Sleep(time.Second)
+		return []byte("{}"), nil
+	}}
+
+	tests := map[string]struct {
+		module  module.Module
+		ctx     func() context.Context
+		args    []string
+		timeout time.Duration
+		wantOut string
+	}{
+		"wrong number of arguments": {
+			module:  echo,
+			args:    []string{"mod"},
+			wantOut: wantRejected(`{"error":"wrong number of arguments: want at least 2 (module, job), got 1"}`),
+		},
+		"unknown module": {
+			module:  echo,
+			args:    []string{"unknown", "job"},
+			wantOut: wantRejected(`{"error":"unknown[job] job is not running or does not support functions"}`),
+		},
+		"unknown job": {
+			module:  echo,
+			args:    []string{"mod", "unknown"},
+			wantOut: wantRejected(`{"error":"mod[unknown] job is not running or does not support functions"}`),
+		},
+		"module doesn't implement FunctionHandler": {
+			module:  &module.MockModule{},
+			args:    []string{"mod", "job"},
+			wantOut: wantRejected(`{"error":"mod[job] job is not running or does not support functions"}`),
+		},
+		"success": {
+			module:  echo,
+			args:    []string{"mod", "job", "arg"},
+			wantOut: wantSucceeded(`{"args":"arg"}`),
+		},
+		"handler error": {
+			module:  failing,
+			args:    []string{"mod", "job"},
+			wantOut: wantRejected(`{"error":"boom"}`),
+		},
+		"timeout": {
+			module:  blocking,
+			args:    []string{"mod", "job"},
+			timeout: time.Millisecond * 50,
+			wantOut: wantRejected(`{"error":"context deadline exceeded"}`),
+		},
+		"timeout, the handler ignores the context": {
+			module:  ignoringCtx,
+			args:    []string{"mod", "job"},
+			timeout: time.Millisecond * 50,
+			wantOut: wantRejected(`{"error":"context deadline exceeded"}`),
+		},
+		"shutdown cancels the call": {
+			module: blocking,
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx
+			},
+			args:    []string{"mod", "job"},
+			wantOut: wantRejected(`{"error":"context canceled"}`),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			mgr := NewManager()
+			mgr.Out = &buf
+			if test.ctx != nil {
+				mgr.ctx = test.ctx()
+			}
+			mgr.queue = append(mgr.queue, module.NewJob(module.JobConfig{
+				Name:       "job",
+				ModuleName: "mod",
+				Module:     test.module,
+			}))
+
+			mgr.ServeModuleFunction(functions.Function{
+				UID:     "uid",
+				Name:    ModuleFunction,
+				Args:    test.args,
+				Timeout: test.timeout,
+			})
+
+			assert.Equal(t, test.wantOut, buf.String())
+		})
+	}
+}
+
+func wantSucceeded(payload string) string {
+	return "FUNCTION_RESULT_BEGIN uid 1 application/json 0\n" + payload + "\nFUNCTION_RESULT_END\n\n"
+}
+
+func wantRejected(payload string) string {
+	return "FUNCTION_RESULT_BEGIN uid 0 application/json 0\n" + payload + "\nFUNCTION_RESULT_END\n\n"
+}
@@ -64,6 +64,8 @@ func NewManager() *Manager {
 
 		addCh:    make(chan confgroup.Config),
 		removeCh: make(chan confgroup.Config),
+
+		ctx: context.Background(),
 	}
 
 	return mgr
@@ -91,12 +93,19 @@ type Manager struct {
 
 	queueMux sync.Mutex
 	queue    []Job
+	// ctx is the Run context, the functions calls contexts are derived from it (guarded by queueMux).
+	ctx context.Context
 }
 
 func (m *Manager) Run(ctx context.Context, in chan []*confgroup.Group) {
 	m.Info("instance is started")
 	defer func() { m.cleanup(); m.Info("instance is stopped") }()
 
+	m.queueMux.Lock()
+	m.ctx = ctx
+	m.queueMux.Unlock()
+	m.declareFunctions()
+
 	var wg sync.WaitGroup
 
 	wg.Add(1)
@@ -181,6 +181,11 @@ func (j Job) Name() string {
 	return j.name
 }
 
+// Module returns job module.
+func (j Job) Module() Module {
+	return j.module
+}
+
 // Panicked returns 'panicked' flag value.
 func (j Job) Panicked() bool {
 	return j.panicked
@@ -3,6 +3,8 @@
 package module
 
 import (
+	"context"
+
 	"github.com/netdata/go.d.plugin/logger"
 )
 
@@ -29,6 +31,13 @@ type Module interface {
 	GetBase() *Base
 }
 
+// FunctionHandler is an optional interface a module can implement to serve on-demand (UI triggered) functions.
+type FunctionHandler interface {
+	// HandleFunction runs the function and returns its JSON encoded result.
+	// The context is cancelled when the function timeout expires.
+	HandleFunction(ctx context.Context, args []string) ([]byte, error)
+}
+
 // Base is a helper struct. All modules should embed this struct.
 type Base struct {
 	*logger.Logger
@@ -194,6 +194,12 @@ func (a *API) DynCfgReportJobStatus(moduleName, jobName, status, reason string)
 	return err
 }
 
+// FUNCTIONGLOBAL declares a global (not bound to a chart) function.
+func (a *API) FUNCTIONGLOBAL(name string, timeout int, help string) error {
+	_, err := fmt.Fprintf(a, "FUNCTION GLOBAL '%s' %d '%s'\n\n", name, timeout, help)
+	return err
+}
+
 func (a *API) FunctionResultSuccess(uid, contentType, payload string) error {
 	return a.functionResult(uid, contentType, payload, "1")
 }
@@ -325,6 +325,19 @@ func TestAPI_DynCfgReportJobStatus(t *testing.T) {
 	)
 }
 
+func TestAPI_FUNCTIONGLOBAL(t *testing.T) {
+	buf := &bytes.Buffer{}
+	a := API{Writer: buf}
+
+	_ = a.FUNCTIONGLOBAL("name", 10, "help")
+
+	assert.Equal(
+		t,
+		"FUNCTION GLOBAL 'name' 10 'help'\n\n",
+		buf.String(),
+	)
+}
+
 func TestAPI_FunctionResultSuccess(t *testing.T) {
 	buf := &bytes.Buffer{}
 	a := API{Writer: buf}
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+// statsKeyAliases are the known variants of the stats keys names, mapped to the canonical (the current ccache)
+// names. ccache 4.4-4.6 named the local and remote storage 'primary' and 'secondary', some wrapper scripts and
+// older builds put the hit kind after the 'cache_hit' prefix.
+var statsKeyAliases = map[string]string{
+	"cache_hit_direct":        "direct_cache_hit",
+	"cache_hit_preprocessed":  "preprocessed_cache_hit",
+	"cache_miss_direct":       "direct_cache_miss",
+	"cache_miss_preprocessed": "preprocessed_cache_miss",
+
+	"primary_storage_hit":       "local_storage_hit",
+	"primary_storage_miss":      "local_storage_miss",
+	"primary_storage_read_hit":  "local_storage_read_hit",
+	"primary_storage_read_miss": "local_storage_read_miss",
+	"primary_storage_write":     "local_storage_write",
+
+	"secondary_storage_error":     "remote_storage_error",
+	"secondary_storage_hit":       "remote_storage_hit",
+	"secondary_storage_miss":      "remote_storage_miss",
+	"secondary_storage_read_hit":  "remote_storage_read_hit",
+	"secondary_storage_read_miss": "remote_storage_read_miss",
+	"secondary_storage_timeout":   "remote_storage_timeout",
+	"secondary_storage_write":     "remote_storage_write",
+}
+
+// normalizeStatsKeys renames the aliased stats keys to their canonical names, so the charts don't depend on
+// the ccache version keys naming. The canonical key wins if the stats have both.
+func normalizeStatsKeys(stats map[string]int64) {
+	for alias, key := range statsKeyAliases {
+		v, ok := stats[alias]
+		if !ok {
+			continue
+		}
+		delete(stats, alias)
+		if _, ok := stats[key]; !ok {
+			stats[key] = v
+		}
+	}
+}
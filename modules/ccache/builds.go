@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// hasBuildCount reports whether a build count source is configured.
+func (c *Ccache) hasBuildCount() bool {
+	return c.BuildCountEnv != "" || c.BuildCountFile != ""
+}
+
+// readBuildCount returns the number of builds that ran against the cache: the 'build_count_file' content, then
+// the 'build_count_env' environment variable. CI updates it, it is read on every collection.
+func (c *Ccache) readBuildCount() (int64, error) {
+	var v, from string
+	switch {
+	case c.BuildCountFile != "":
+		bs, err := os.ReadFile(c.BuildCountFile)
+		if err != nil {
+			return 0, err
+		}
+		v, from = string(bs), fmt.Sprintf("'%s'", c.BuildCountFile)
+	default:
+		v, from = os.Getenv(c.BuildCountEnv), fmt.Sprintf("$%s", c.BuildCountEnv)
+	}
+
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, fmt.Errorf("%s is empty", from)
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", from, err)
+	}
+	if n <= 0 {
+		return 0, errors.New(from + ": no builds")
+	}
+	return n, nil
+}
+
+// deriveCallsPerBuild reports the lifetime hits and misses per build, the cumulative counters are comparable across
+// machines with different build volumes only normalized by the builds count. Nothing is reported while the builds
+// count is unavailable (not yet written by CI, no builds yet); the failure is logged once until the count is read.
+func (c *Ccache) deriveCallsPerBuild(mx, stats map[string]int64) {
+	if !c.hasBuildCount() {
+		return
+	}
+
+	builds, err := c.readBuildCount()
+	if err != nil {
+		if !c.buildCountFailed {
+			c.Debugf("build count is not available, no per build metrics: %v", err)
+		}
+		c.buildCountFailed = true
+		return
+	}
+	c.buildCountFailed = false
+
+	calls := newCallsStats(stats)
+	mx["hits_per_build"] = calls.hits * precision / builds
+	mx["misses_per_build"] = calls.misses * precision / builds
+}
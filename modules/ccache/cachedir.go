@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// resolveCacheDir returns the cache directory with the symlinks resolved. The cache dir is often a symlink into
+// a mounted volume (containers), the stats files scans and the shards enumeration must target the volume.
+// The configured path is returned if it can't be resolved (e.g. doesn't exist yet, ccache creates it on the first use).
+func (c *Ccache) resolveCacheDir() string {
+	dir := c.configuredCacheDir()
+	if dir == "" {
+		return ""
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return dir
+	}
+	return resolved
+}
+
+// configuredCacheDir returns the cache directory the same way ccache does:
+// 'cache_dir' option, $CCACHE_DIR, legacy ~/.ccache (if it exists), $XDG_CACHE_HOME/ccache, ~/.cache/ccache.
+func (c *Ccache) configuredCacheDir() string {
+	if c.CacheDir != "" {
+		return c.CacheDir
+	}
+	if v := c.getenv("CCACHE_DIR"); v != "" {
+		return v
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if legacy := filepath.Join(home, ".ccache"); isDir(legacy) {
+		return legacy
+	}
+	if v := c.getenv("XDG_CACHE_HOME"); v != "" {
+		return filepath.Join(v, "ccache")
+	}
+	return filepath.Join(home, ".cache", "ccache")
+}
+
+// resolveConfigPath returns the ccache config file: 'config_path' option, $CCACHE_CONFIGPATH,
+// 'ccache.conf' in the cache directory.
+func (c *Ccache) resolveConfigPath() string {
+	if c.ConfigPath != "" {
+		return c.ConfigPath
+	}
+	if v := c.getenv("CCACHE_CONFIGPATH"); v != "" {
+		return v
+	}
+	if dir := c.resolveCacheDir(); dir != "" {
+		return filepath.Join(dir, "ccache.conf")
+	}
+	return ""
+}
+
+// getenv returns the variable the ccache executions see: the 'environment' option value, the agent environment one.
+func (c *Ccache) getenv(key string) string {
+	if v, ok := c.Environment[key]; ok {
+		return v
+	}
+	return os.Getenv(key)
+}
+
+// statsFilesPatterns match ccache stats files: '<dir>/stats' (3.x), '<dir>/<x>/stats' and '<dir>/<x>/<y>/stats' (4.x).
+var statsFilesPatterns = []string{
+	"stats",
+	filepath.Join("?", "stats"),
+	filepath.Join("?", "?", "stats"),
+}
+
+func findStatsFiles(cacheDir string) ([]string, error) {
+	var files []string
+	for _, pattern := range statsFilesPatterns {
+		matches, err := filepath.Glob(filepath.Join(cacheDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// lastStatsModTime returns the most recent modification time of the cache stats files.
+func lastStatsModTime(cacheDir string) (time.Time, error) {
+	files, err := findStatsFiles(cacheDir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(files) == 0 {
+		return time.Time{}, errors.New("no stats files found")
+	}
+
+	var last time.Time
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+	return last, nil
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// maxCacheDirs bounds the number of caches discovered under 'cache_dirs_root'.
+const maxCacheDirs = 100
+
+// maxCacheDirNotSeenTimes is the number of consecutive collections a cache can be missing from before it is dropped.
+const maxCacheDirNotSeenTimes = 5
+
+// discoverCacheDirs returns the 'root' subdirectories that are ccache caches (have stats files).
+// Unreadable subdirectories are skipped.
+func (c *Ccache) discoverCacheDirs(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, e.Name())
+		files, err := findStatsFiles(dir)
+		if err != nil {
+			c.Debugf("cache dirs discovery: '%s': %v", dir, err)
+			continue
+		}
+		if len(files) == 0 {
+			continue
+		}
+		if len(dirs) == maxCacheDirs {
+			c.Warningf("cache dirs discovery: more than %d caches found in '%s', ignoring the rest", maxCacheDirs, root)
+			break
+		}
+		dirs = append(dirs, dir)
+	}
+
+	return dirs, nil
+}
+
+// queryCacheDirsStats reads the stats files of every cache found under 'cache_dirs_root' and sums their counters.
+// Like queryStatsFiles, the reads can't block the collection longer than 'timeout'.
+func (c *Ccache) queryCacheDirsStats() (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, c.Timeout.Duration)
+	defer cancel()
+
+	type result struct {
+		perDir map[string]map[string]int64
+		err    error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		perDir, err := c.readCacheDirs(ctx)
+		ch <- result{perDir: perDir, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("read '%s' caches stats files: %v", c.CacheDirsRoot, ctx.Err())
+	case res := <-ch:
+		if res.err != nil {
+			return nil, res.err
+		}
+		perDir := c.keepMissingCacheDirs(res.perDir)
+		if len(perDir) == 0 {
+			return nil, fmt.Errorf("no caches found in '%s'", c.CacheDirsRoot)
+		}
+
+		total := make(map[string]int64)
+		for _, stats := range perDir {
+			for k, v := range stats {
+				total[k] += v
+			}
+		}
+		c.cacheDirsStats = perDir
+		return total, nil
+	}
+}
+
+func (c *Ccache) readCacheDirs(ctx context.Context) (map[string]map[string]int64, error) {
+	dirs, err := c.discoverCacheDirs(c.CacheDirsRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	perDir := make(map[string]map[string]int64)
+
+	for _, dir := range dirs {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		stats, _, err := c.readStatsFiles(ctx, dir)
+		if err != nil {
+			c.Debugf("read cache '%s' stats files: %v", dir, err)
+			continue
+		}
+		perDir[dir] = stats
+	}
+
+	return perDir, nil
+}
+
+// keepMissingCacheDirs adds the last stats of the caches missing from the collection. A cache being cleaned or
+// recreated can be briefly unreadable, dropping it would lower the totals (a drop looks like a stats reset) and
+// remove its charts. A cache is dropped once it has been missing for maxCacheDirNotSeenTimes collections.
+func (c *Ccache) keepMissingCacheDirs(perDir map[string]map[string]int64) map[string]map[string]int64 {
+	for dir, stats := range c.cacheDirsStats {
+		if _, ok := perDir[dir]; ok {
+			delete(c.cacheDirsNotSeen, dir)
+			continue
+		}
+		if c.cacheDirsNotSeen[dir]++; c.cacheDirsNotSeen[dir] >= maxCacheDirNotSeenTimes {
+			c.Debugf("cache '%s' is missing for %d collections, dropping it", dir, c.cacheDirsNotSeen[dir])
+			delete(c.cacheDirsNotSeen, dir)
+			continue
+		}
+		perDir[dir] = stats
+	}
+	return perDir
+}
+
+func (c *Ccache) collectCacheDirsStats(mx map[string]int64) {
+	dirs := make([]string, 0, len(c.cacheDirsStats))
+	for dir := range c.cacheDirsStats {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	seen := make(map[string]string)
+	for _, dir := range dirs {
+		stats := c.cacheDirsStats[dir]
+		name := cacheDirName(dir)
+		if other, ok := seen[name]; ok {
+			c.Debugf("cache '%s' has the same charts name '%s' as '%s', skipping its breakdown", dir, name, other)
+			continue
+		}
+		seen[name] = dir
+
+		if !c.cacheDirsCharts[name] {
+			c.cacheDirsCharts[name] = true
+			c.addCacheDirCharts(name, dir)
+		}
+		px := "cache_dir_" + name + "_"
+		mx[px+"cache_hit"] = stats["direct_cache_hit"] + stats["preprocessed_cache_hit"]
+		mx[px+"cache_miss"] = stats["cache_miss"]
+	}
+
+	for name := range c.cacheDirsCharts {
+		if _, ok := mx["cache_dir_"+name+"_cache_hit"]; !ok {
+			delete(c.cacheDirsCharts, name)
+			c.removeCacheDirCharts(name)
+		}
+	}
+}
+
+// cacheDirName is the cache name in the charts and dimensions IDs. A name changed by the sanitization gets a short
+// hash of the original name as a suffix, so sibling caches ('team.a' and 'team_a') don't share the IDs.
+func cacheDirName(dir string) string {
+	base := filepath.Base(dir)
+	name := sanitizeID(base)
+	if name == base {
+		return name
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(base))
+	return fmt.Sprintf("%s_%08x", name, h.Sum32())
+}
@@ -0,0 +1,570 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/netdata/go.d.plugin/agent/module"
+	"github.com/netdata/go.d.plugin/logger"
+	"github.com/netdata/go.d.plugin/pkg/web"
+)
+
+//go:embed "config_schema.json"
+var configSchema string
+
+func init() {
+	module.Register("ccache", module.Creator{
+		JobConfigSchema: configSchema,
+		Defaults: module.Defaults{
+			UpdateEvery: 10,
+		},
+		Create: func() module.Module { return New() },
+	})
+}
+
+func New() *Ccache {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Ccache{
+		Config: Config{
+			BinaryPath:          "ccache",
+			Timeout:             web.Duration{Duration: time.Second * 2},
+			CollectionMode:      string(collectionModeExec),
+			Tool:                string(toolCcache),
+			PercentageChartType: string(module.Stacked),
+			HitRateBasis:        string(hitRateBasisHitsMisses),
+			DumpFileMaxSize:     defaultDumpFileMaxSize,
+			UnknownKeyPolicy:    string(unknownKeyPolicyIgnore),
+
+			EffectiveHitRateThreshold: defaultEffectiveHitRateThreshold,
+			FileCountMaxDiscrepancy:   defaultFileCountMaxDiscrepancy,
+		},
+		ctx:               ctx,
+		cancel:            cancel,
+		charts:            baseCharts.Copy(),
+		collectedStats:    make(map[string]bool),
+		prevCounters:      make(map[string]int64),
+		counterOffsets:    make(map[string]int64),
+		cacheDirsCharts:   make(map[string]bool),
+		cacheDirsNotSeen:  make(map[string]int),
+		warnedUnknownKeys: make(map[string]bool),
+		rawOutputLogEvery: time.Minute,
+		readFile:          os.ReadFile,
+		shardBalanceEvery: time.Minute * 5,
+		fileCountEvery:    time.Minute * 5,
+		versionCheckEvery: time.Minute * 5,
+	}
+}
+
+type Config struct {
+	Timeout        web.Duration
+	CollectionMode string `yaml:"collection_mode"`
+	// Tool is the compiler cache: 'ccache' or 'sccache' (its stats are mapped to the ccache ones where they overlap).
+	Tool       string `yaml:"tool"`
+	BinaryPath string `yaml:"binary_path"`
+	// ExpectedVersion is the version the 'binary_path' binary must report ('4.8' matches any 4.8.x), a mismatch is
+	// warned about. It guards against another binary found in PATH (e.g. environment modules).
+	ExpectedVersion string `yaml:"expected_version"`
+	// CommandWrapper is a command (and its arguments) the stats commands are run through,
+	// e.g. ['podman', 'unshare'] to read a rootless container cache in its user namespace.
+	CommandWrapper []string `yaml:"command_wrapper"`
+	// Cgroup is a cgroup v2 directory the ccache executions are placed into (Linux), for resource accounting.
+	Cgroup string `yaml:"cgroup"`
+	// SafeMode forbids executing any process (ccache, its version/help probes, 'command_wrapper'),
+	// a non-exec collection mode must be configured.
+	SafeMode bool   `yaml:"safe_mode"`
+	CacheDir string `yaml:"cache_dir"`
+	// Environment and ConfigPath are the ccache environment ($CCACHE_*) and config file of the builds. They are applied
+	// to every ccache execution and to the config the collector reads itself, so all of them see the builds' config.
+	Environment map[string]string `yaml:"environment"`
+	ConfigPath  string            `yaml:"config_path"`
+	// CacheDirsRoot is a directory with several caches as subdirectories (e.g. per-user caches), 'dirs' collection mode.
+	CacheDirsRoot string   `yaml:"cache_dirs_root"`
+	Sources       []string `yaml:"sources"`
+	// FIFOPath is a named pipe a sidecar writes the stats (json or text format) to, 'fifo' collection mode.
+	FIFOPath string `yaml:"fifo_path"`
+	// URL is an HTTP endpoint serving the stats in the text or json format, it is used instead of executing ccache.
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Mirror is a read-only copy of the stats (a file path or an http(s) URL, the text or json format), 'mirror' source.
+	// The stats are rejected if the copy is older than MirrorMaxAge (0 disables the check).
+	Mirror       string       `yaml:"mirror"`
+	MirrorMaxAge web.Duration `yaml:"mirror_max_age"`
+
+	DebugRawOutput bool `yaml:"debug_raw_output"`
+	SkipIfIdle     bool `yaml:"skip_if_idle"`
+	SinceStart     bool `yaml:"since_start"`
+	ShardBalance   bool `yaml:"shard_balance"`
+	SelfTest       bool `yaml:"self_test"`
+	// StatLatencyProbe times a stat of an absent entry in the cache directory every collection. It is opt-in: the probe
+	// touches the cache directory.
+	StatLatencyProbe bool `yaml:"stat_latency_probe"`
+	// FileCountCheck compares 'files_in_cache' with the cache files on disk ('file' collection mode), a divergence
+	// bigger than FileCountMaxDiscrepancy (percent) is warned about. It is opt-in: the count walks the cache directory.
+	FileCountCheck          bool    `yaml:"file_count_check"`
+	FileCountMaxDiscrepancy float64 `yaml:"file_count_max_discrepancy"`
+	// AvgCompileSeconds is the average compilation time of the builds (a cache miss) and AvgHitSeconds the average
+	// time of a cache hit, together they enable the estimated build time saved metric. 0 disables it.
+	AvgCompileSeconds float64 `yaml:"avg_compile_seconds"`
+	AvgHitSeconds     float64 `yaml:"avg_hit_seconds"`
+	// SloppinessProbe reads the ccache 'sloppiness' flags once (exec collection mode), they are the charts label.
+	SloppinessProbe bool `yaml:"sloppiness_probe"`
+
+	// EffectiveHitRateThreshold is the recent hit ratio (percent) above which an active cache is effective.
+	EffectiveHitRateThreshold float64 `yaml:"effective_hit_rate_threshold"`
+
+	PercentageChartType string `yaml:"percentage_chart_type"`
+	// HitRateBasis is the hit/miss percentages denominator: 'hits_misses' (the cacheable calls) or 'all_calls'.
+	HitRateBasis string `yaml:"hit_rate_basis"`
+	// ContextPrefix namespaces the charts contexts (e.g. 'staging' makes 'staging.ccache.local_storage').
+	ContextPrefix string `yaml:"context_prefix"`
+	// TitlePrefix is prepended to the charts titles, Family replaces the charts families. Both tell apart the charts
+	// of several jobs (e.g. 'Frontend CI cache').
+	TitlePrefix string `yaml:"title_prefix"`
+	Family      string `yaml:"family"`
+	// Priority is the job priority (module.Defaults.Priority if not set in the job config),
+	// the charts priorities are shifted by its difference from the default module priority.
+	Priority int `yaml:"priority"`
+
+	BuildID    string `yaml:"build_id"`
+	BuildIDEnv string `yaml:"build_id_env"`
+	// BuildCountFile and BuildCountEnv are the builds count source (a file with the count, an environment variable),
+	// they enable the hits and misses per build.
+	BuildCountFile string `yaml:"build_count_file"`
+	BuildCountEnv  string `yaml:"build_count_env"`
+
+	PassthroughAllKeys bool   `yaml:"passthrough_all_keys"`
+	UnknownKeyPolicy   string `yaml:"unknown_key_policy"`
+	// SuppressMetrics are the metrics IDs dropped from the collected metrics and the charts dimensions.
+	SuppressMetrics []string `yaml:"suppress_metrics"`
+
+	DumpFile        string `yaml:"dump_file"`
+	DumpFileMaxSize int64  `yaml:"dump_file_max_size"`
+	// StateFile persists the windowed derived metrics samples, so they survive agent restarts.
+	StateFile string `yaml:"state_file"`
+
+	Nodes         []NodeConfig `yaml:"nodes"`
+	NodeBreakdown bool         `yaml:"node_breakdown"`
+}
+
+type (
+	Ccache struct {
+		module.Base
+		Config `yaml:",inline"`
+
+		// ctx is cancelled on Cleanup() (job stop, agent shutdown), it aborts the in-flight ccache executions,
+		// remote requests and stats files scans, so a running collection returns promptly.
+		ctx      context.Context
+		cancel   context.CancelFunc
+		inflight sync.WaitGroup
+
+		charts         *module.Charts
+		priorityOffset int
+
+		exec     ccacheCLI
+		readFile func(name string) ([]byte, error)
+
+		sources      []statsSource
+		activeSource string
+		// mirrorAge is the stats mirror age as of the last read (hasMirrorAge is false if unknown), mirrorStale is set
+		// while it is older than 'mirror_max_age'.
+		mirrorAge    time.Duration
+		hasMirrorAge bool
+		mirrorStale  bool
+
+		urlNode    *cacheNode
+		mirrorNode *cacheNode
+		nodes      []*cacheNode
+		nodesStats map[string]map[string]int64
+
+		// statsFormat (and the legacy format flag) is negotiated once in Init() and used by every collection.
+		// It is renegotiated if the version changes, formatMux guards the writes and the function reads.
+		formatMux     sync.Mutex
+		statsFormat   statsFormat
+		showStatsFlag string
+		version       string
+		buildID       string
+		// distributedCompiler is the distributed compiler ccache hands the misses to ('distcc', 'icecc'), if any.
+		distributedCompiler string
+		// sloppiness are the enabled ccache 'sloppiness' flags, nil if not resolved ('sloppiness_probe').
+		sloppiness []string
+
+		versionCheckTime  time.Time
+		versionCheckEvery time.Duration
+		// versionMatchesExpected is whether the version matches 'expected_version', mismatchedVersion is the last
+		// mismatching version warned about.
+		versionMatchesExpected bool
+		mismatchedVersion      string
+
+		collectedStats    map[string]bool
+		warnedUnknownKeys map[string]bool
+		suppressedMetrics map[string]bool
+		prevStats         map[string]int64
+		// statsTime is when the stats were last fetched (not reused).
+		statsTime time.Time
+		// statsSections are the stats sections of the last '--show-stats' output, empty for the other formats.
+		statsSections map[string]statsSection
+		// baseStats is the counters snapshot the 'since start' values are relative to.
+		baseStats map[string]int64
+
+		prevRecentHitRatio    int64
+		hasPrevRecentHitRatio bool
+
+		// estimatedIOSaved is the accumulated estimate of the disk I/O avoided by cache hits, in bytes.
+		estimatedIOSaved int64
+
+		// sizeSamples is the bounded cache size history the growth rate (bytes per day) is fitted to.
+		sizeSamples    []sizeSample
+		cacheGrowth    int64
+		hasCacheGrowth bool
+		// cacheMaxSize is the ccache 'max_size' in bytes, 0 if unknown.
+		cacheMaxSize int64
+		fillWarned   bool
+
+		prevCounters   map[string]int64
+		counterOffsets map[string]int64
+		// cacheResets is the number of observed stats resets ('ccache -z'), see isStatsReset.
+		cacheResets int64
+
+		lastCleanupTime time.Time
+
+		// statsFilesScan is the summary of the last stats files read ('file' collection mode).
+		statsFilesScan statsFilesScan
+
+		cacheSizeKey string
+		cacheSizeMul int64
+
+		cacheDir string
+		// cacheDirsStats are the per cache stats of the last 'dirs' collection mode collection, cacheDirsNotSeen
+		// the number of consecutive collections a cache has been missing from.
+		cacheDirsStats   map[string]map[string]int64
+		cacheDirsCharts  map[string]bool
+		cacheDirsNotSeen map[string]int
+		statsModTime     time.Time
+
+		rawOutputLogTime  time.Time
+		rawOutputLogEvery time.Duration
+
+		// statProbes is the number of stat latency probes, it picks the probed shard directory.
+		statProbes int
+
+		shardBalance      map[string]int64
+		shardBalanceTime  time.Time
+		shardBalanceEvery time.Duration
+
+		// fileCountDiscrepancy is the cache files on disk minus 'files_in_cache' as of the last count.
+		fileCountDiscrepancy int64
+		hasFileCount         bool
+		fileCountWarned      bool
+		fileCountTime        time.Time
+		fileCountEvery       time.Duration
+
+		dumpFailed       bool
+		stateFailed      bool
+		buildCountFailed bool
+
+		// snapshot is the last collection the 'openmetrics' function renders, guarded by snapshotMux.
+		snapshotMux sync.Mutex
+		snapshot    *metricsSnapshot
+
+		// collectErrors is the number of failed collections (no data), it is reported by the next successful one.
+		collectErrors int64
+		// successStreak and failStreak are the current consecutive successful/failed collections counts.
+		successStreak int64
+		failStreak    int64
+
+		health HealthSummary
+		// lastCollectErr is the last collection error, Check() logs its class.
+		lastCollectErr error
+	}
+	ccacheCLI interface {
+		version() ([]byte, error)
+		help() ([]byte, error)
+		printStatsJSON() ([]byte, error)
+		printStats() ([]byte, error)
+		showStats(flag string) ([]byte, error)
+		sccacheStats() ([]byte, error)
+		printConfig() ([]byte, error)
+	}
+)
+
+func (c *Ccache) Init() bool {
+	if c.URL != "" && collectionMode(c.CollectionMode) == collectionModeExec {
+		c.CollectionMode = string(collectionModeURL)
+	}
+	if tool(c.Tool) == toolSccache && c.BinaryPath == string(toolCcache) {
+		c.BinaryPath = string(toolSccache)
+	}
+
+	if err := c.validateConfig(); err != nil {
+		c.Errorf("config validation: %v", err)
+		return false
+	}
+
+	if c.Priority > 0 {
+		c.priorityOffset = c.Priority - module.Priority
+	}
+	c.initSuppressedMetrics()
+	for _, chart := range slices.Clone(*c.charts) {
+		c.adjustChart(chart)
+		if !c.suppressDims(chart) {
+			_ = c.charts.Remove(chart.ID)
+		}
+	}
+
+	if c.Cgroup != "" {
+		if err := checkCgroup(c.Cgroup); err != nil {
+			c.Warningf("'cgroup' '%s': %v, running ccache without it", c.Cgroup, err)
+			c.Cgroup = ""
+		}
+	}
+
+	if collectionMode(c.CollectionMode) == collectionModeExec {
+		if c.exec == nil {
+			ce, err := c.initCcacheExec()
+			if err != nil {
+				c.Errorf("init ccache exec: %v", err)
+				return false
+			}
+			c.exec = ce
+		}
+
+		f, err := c.negotiateStatsFormat()
+		if err != nil {
+			var hint string
+			if len(c.CommandWrapper) > 0 {
+				hint = fmt.Sprintf(" (run through 'command_wrapper' %v, entering the namespace may have failed)", c.CommandWrapper)
+			}
+			c.logger(err).Errorf("negotiate stats format: %v%s", err, hint)
+			return false
+		}
+		c.setStatsFormat(f)
+		c.versionCheckTime = time.Now()
+		c.Debugf("using '%s' stats format", f)
+
+		if c.ExpectedVersion != "" {
+			c.checkExpectedVersion()
+			c.addVersionMatchesExpectedCharts()
+		}
+	} else if c.ExpectedVersion != "" {
+		c.Warningf("'expected_version' is supported only in '%s' collection mode, ignoring it", collectionModeExec)
+		c.ExpectedVersion = ""
+	}
+
+	if collectionMode(c.CollectionMode) == collectionModeSources {
+		sources, err := c.initSources()
+		if err != nil {
+			c.logger(err).Errorf("init sources: %v", err)
+			return false
+		}
+		c.sources = sources
+	}
+
+	if collectionMode(c.CollectionMode) == collectionModeURL {
+		node, err := c.initURLNode()
+		if err != nil {
+			c.Errorf("init url: %v", err)
+			return false
+		}
+		c.urlNode = node
+	}
+
+	if collectionMode(c.CollectionMode) == collectionModeNodes {
+		nodes, err := c.initNodes()
+		if err != nil {
+			c.Errorf("init nodes: %v", err)
+			return false
+		}
+		c.nodes = nodes
+	}
+	if c.NodeBreakdown {
+		for _, node := range c.nodes {
+			c.addNodeCharts(node)
+		}
+	}
+
+	if c.SkipIfIdle && slices.Contains([]collectionMode{collectionModeNodes, collectionModeURL, collectionModeDirs, collectionModeFIFO}, collectionMode(c.CollectionMode)) {
+		c.Warningf("'skip_if_idle' is not supported in '%s' collection mode, ignoring it", c.CollectionMode)
+		c.SkipIfIdle = false
+	}
+
+	if tool(c.Tool) == toolSccache && (c.SkipIfIdle || c.SelfTest) {
+		c.Warningf("'skip_if_idle' and 'self_test' are not supported for '%s', ignoring them", toolSccache)
+		c.SkipIfIdle, c.SelfTest = false, false
+	}
+
+	if c.SelfTest && collectionMode(c.CollectionMode) != collectionModeExec {
+		c.Warningf("'self_test' is supported only in '%s' collection mode, ignoring it", collectionModeExec)
+		c.SelfTest = false
+	}
+
+	if c.StatLatencyProbe && slices.Contains([]collectionMode{collectionModeNodes, collectionModeURL, collectionModeDirs, collectionModeFIFO}, collectionMode(c.CollectionMode)) {
+		c.Warningf("'stat_latency_probe' is not supported in '%s' collection mode, ignoring it", c.CollectionMode)
+		c.StatLatencyProbe = false
+	}
+
+	if c.SkipIfIdle || c.SelfTest || c.StatLatencyProbe || collectionMode(c.CollectionMode) == collectionModeFile || c.hasSource(sourceStatsFile) {
+		c.cacheDir = c.resolveCacheDir()
+		if c.cacheDir == "" {
+			c.Error("can not resolve ccache cache directory, set 'cache_dir'")
+			return false
+		}
+		if dir := c.configuredCacheDir(); dir != c.cacheDir {
+			c.Debugf("cache directory '%s' resolves to '%s'", dir, c.cacheDir)
+		}
+		c.Debugf("reading '%s' stats files", c.cacheDir)
+	}
+
+	if c.SinceStart {
+		c.addSinceStartCharts()
+	}
+	if collectionMode(c.CollectionMode) == collectionModeFile {
+		c.addStatsFilesCharts()
+	}
+	if c.estimatesTimeSaved() {
+		c.addEstimatedTimeSavedCharts()
+	}
+	if c.hasBuildCount() {
+		c.addCallsPerBuildCharts()
+	}
+	if c.ShardBalance {
+		if collectionMode(c.CollectionMode) == collectionModeFile {
+			c.addShardBalanceCharts()
+		} else {
+			c.Warningf("'shard_balance' is supported only in '%s' collection mode, ignoring it", collectionModeFile)
+			c.ShardBalance = false
+		}
+	}
+	if c.FileCountCheck {
+		if collectionMode(c.CollectionMode) == collectionModeFile {
+			c.addFileCountDiscrepancyCharts()
+		} else {
+			c.Warningf("'file_count_check' is supported only in '%s' collection mode, ignoring it", collectionModeFile)
+			c.FileCountCheck = false
+		}
+	}
+
+	c.setPercentageChartsType()
+	c.applyHitRateBasis()
+
+	c.buildID = c.resolveBuildID()
+	if c.buildID != "" {
+		c.Debugf("build id: '%s'", c.buildID)
+	}
+
+	c.cacheMaxSize = c.resolveCacheMaxSize()
+
+	if c.StateFile != "" {
+		c.loadState()
+	}
+
+	c.distributedCompiler = c.resolveDistributedCompiler()
+	if c.distributedCompiler != "" {
+		c.Debugf("ccache hands the misses to '%s', the hits and the uncacheable calls are handled locally", c.distributedCompiler)
+	}
+
+	if c.SloppinessProbe {
+		if collectionMode(c.CollectionMode) != collectionModeExec {
+			c.Debugf("'sloppiness_probe' is supported only in '%s' collection mode, ignoring it", collectionModeExec)
+		} else if flags, err := c.resolveSloppiness(); err != nil {
+			c.Debugf("can not read the sloppiness flags, skipping them: %v", err)
+		} else {
+			c.sloppiness = flags
+			c.Debugf("sloppiness flags: %v", flags)
+			c.addSloppinessCharts()
+		}
+	}
+
+	return true
+}
+
+func (c *Ccache) Check() bool {
+	if len(c.Collect()) > 0 {
+		return true
+	}
+	class := classifyCollectError(c.lastCollectErr)
+	c.Errorf("check failed (%s): %s", class, c.checkErrorHint(class))
+	return false
+}
+
+func (c *Ccache) Charts() *module.Charts {
+	return c.charts
+}
+
+func (c *Ccache) Collect() map[string]int64 {
+	mx, err := c.collect()
+	if err != nil {
+		c.logger(err).Error(err)
+	}
+	c.lastCollectErr = err
+	c.updateHealth(mx, err)
+
+	if len(mx) == 0 {
+		c.collectErrors++
+		c.failStreak++
+		c.successStreak = 0
+		return nil
+	}
+	mx["collect_errors"] = c.collectErrors
+
+	// a failed collection has no data: the failures streak is reported by the successful collection that ends it
+	c.successStreak++
+	mx["consecutive_successful_collects"] = c.successStreak
+	mx["consecutive_failed_collects"] = c.failStreak
+	c.failStreak = 0
+
+	c.suppressMetrics(mx)
+	c.takeMetricsSnapshot(mx)
+
+	if c.DumpFile != "" {
+		c.dumpMetrics(mx)
+	}
+	return mx
+}
+
+// cleanupDrainTimeout is how long Cleanup() waits for the cancelled remote requests to unwind.
+const cleanupDrainTimeout = time.Second
+
+func (c *Ccache) Cleanup() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+
+	done := make(chan struct{})
+	go func() { c.inflight.Wait(); close(done) }()
+
+	select {
+	case <-done:
+	case <-time.After(cleanupDrainTimeout):
+		c.Warningf("cleanup: in-flight remote requests did not finish in %s", cleanupDrainTimeout)
+	}
+
+	for _, node := range c.nodes {
+		node.httpClient.CloseIdleConnections()
+	}
+	if c.urlNode != nil {
+		c.urlNode.httpClient.CloseIdleConnections()
+	}
+	if c.mirrorNode != nil {
+		c.mirrorNode.httpClient.CloseIdleConnections()
+	}
+}
+
+// logger returns the job logger, enriched with the failed command context if err is an exec error.
+func (c *Ccache) logger(err error) *logger.Logger {
+	var ee *execError
+	if errors.As(err, &ee) {
+		return c.Logger.With(ee.logAttrs()...)
+	}
+	return c.Logger
+}
@@ -0,0 +1,3631 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netdata/go.d.plugin/agent/module"
+	"github.com/netdata/go.d.plugin/pkg/web"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	dataVer24Version, _ = os.ReadFile("testdata/version-2.4.txt")
+	dataVer24Help, _    = os.ReadFile("testdata/help-2.4.txt")
+
+	dataVer34Version, _   = os.ReadFile("testdata/version-3.4.txt")
+	dataVer34Help, _      = os.ReadFile("testdata/help-3.4.txt")
+	dataVer34ShowStats, _ = os.ReadFile("testdata/show-stats-3.4.txt")
+
+	dataVer34ShowStatsCRLF, _ = os.ReadFile("testdata/show-stats-3.4-crlf.txt")
+
+	dataVer48Version, _    = os.ReadFile("testdata/version-4.8.txt")
+	dataVer48Help, _       = os.ReadFile("testdata/help-4.8.txt")
+	dataVer48PrintStats, _ = os.ReadFile("testdata/print-stats-4.8.txt")
+
+	dataVer48PrintStatsDecorated, _ = os.ReadFile("testdata/print-stats-4.8-decorated.txt")
+	dataVer48PrintStatsCRLF, _      = os.ReadFile("testdata/print-stats-4.8-crlf.txt")
+	dataVer48ShowStatsVerbose, _    = os.ReadFile("testdata/show-stats-4.8-verbose.txt")
+	dataVer48ShowStatsCRLF, _       = os.ReadFile("testdata/show-stats-4.8-crlf.txt")
+	dataVer48ShowConfig, _          = os.ReadFile("testdata/show-config-4.8.txt")
+
+	dataSccache07Version, _   = os.ReadFile("testdata/version-sccache-0.7.txt")
+	dataSccache07StatsJSON, _ = os.ReadFile("testdata/show-stats-sccache-0.7.json")
+
+	dataVer410Version, _        = os.ReadFile("testdata/version-4.10.txt")
+	dataVer410Help, _           = os.ReadFile("testdata/help-4.10.txt")
+	dataVer410PrintStatsJSON, _ = os.ReadFile("testdata/print-stats-4.10.json")
+
+	dataVer410PrintStatsJSONGzip, _ = os.ReadFile("testdata/print-stats-4.10.json.gz")
+)
+
+func Test_testDataIsValid(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"dataVer24Version":    dataVer24Version,
+		"dataVer24Help":       dataVer24Help,
+		"dataVer34Version":    dataVer34Version,
+		"dataVer34Help":       dataVer34Help,
+		"dataVer34ShowStats":  dataVer34ShowStats,
+		"dataVer48Version":    dataVer48Version,
+		"dataVer48Help":       dataVer48Help,
+		"dataVer48PrintStats": dataVer48PrintStats,
+
+		"dataVer34ShowStatsCRLF":       dataVer34ShowStatsCRLF,
+		"dataVer48PrintStatsDecorated": dataVer48PrintStatsDecorated,
+		"dataVer48PrintStatsCRLF":      dataVer48PrintStatsCRLF,
+		"dataVer48ShowStatsVerbose":    dataVer48ShowStatsVerbose,
+		"dataVer48ShowStatsCRLF":       dataVer48ShowStatsCRLF,
+		"dataVer48ShowConfig":          dataVer48ShowConfig,
+		"dataSccache07Version":         dataSccache07Version,
+		"dataSccache07StatsJSON":       dataSccache07StatsJSON,
+		"dataVer410Version":            dataVer410Version,
+		"dataVer410Help":               dataVer410Help,
+		"dataVer410PrintStatsJSON":     dataVer410PrintStatsJSON,
+		"dataVer410PrintStatsJSONGzip": dataVer410PrintStatsJSONGzip,
+	} {
+		require.NotNilf(t, data, name)
+	}
+}
+
+func TestCcache_Init(t *testing.T) {
+	tests := map[string]struct {
+		prepare  func(c *Ccache)
+		wantFail bool
+	}{
+		"fails if 'binary_path' not set": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.BinaryPath = ""
+			},
+		},
+		"fails if can't locate ccache": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.BinaryPath += "!!!"
+			},
+		},
+		"fails if 'ccache --version' returns an error": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.exec = prepareMockVer48()
+				c.exec.(*mockCcacheExec).errOnVersion = true
+			},
+		},
+		"success if 'ccache --help' returns an error": {
+			wantFail: false,
+			prepare: func(c *Ccache) {
+				c.exec = prepareMockVer48()
+				c.exec.(*mockCcacheExec).errOnHelp = true
+			},
+		},
+		"success with ccache 4.8": {
+			wantFail: false,
+			prepare: func(c *Ccache) {
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails with 'effective_hit_rate_threshold' above 100": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.EffectiveHitRateThreshold = 101
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails on unknown 'collection_mode'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.CollectionMode = "socket"
+			},
+		},
+		"fails in fifo mode without 'fifo_path'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.CollectionMode = string(collectionModeFIFO)
+			},
+		},
+		"fails in nodes mode without nodes": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.CollectionMode = string(collectionModeNodes)
+			},
+		},
+		"fails on negative 'priority'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.Priority = -1
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails with empty 'command_wrapper' command": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.CommandWrapper = []string{"", "unshare"}
+			},
+		},
+		"fails if 'command_wrapper' is not found": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.CommandWrapper = []string{"no-such-podman", "unshare"}
+			},
+		},
+		"fails on unknown 'tool'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.Tool = "buildcache"
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails with sccache outside exec mode": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.Tool = string(toolSccache)
+				c.CollectionMode = string(collectionModeFile)
+				c.CacheDir = "testdata"
+			},
+		},
+		"success with sccache": {
+			wantFail: false,
+			prepare: func(c *Ccache) {
+				c.Tool = string(toolSccache)
+				c.exec = prepareMockSccache07()
+			},
+		},
+		"fails on unknown 'unknown_key_policy'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.UnknownKeyPolicy = "drop"
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails in sources mode without sources": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.CollectionMode = string(collectionModeSources)
+			},
+		},
+		"fails in sources mode with unknown source": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.CollectionMode = string(collectionModeSources)
+				c.Sources = []string{sourceJSONExec, "ssh"}
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails with the mirror source without 'mirror'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.CollectionMode = string(collectionModeSources)
+				c.Sources = []string{sourceMirror}
+			},
+		},
+		"fails with unknown 'hit_rate_basis'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.HitRateBasis = "cacheable"
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails with invalid 'expected_version'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.ExpectedVersion = "v4.8"
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails with negative 'file_count_max_discrepancy'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.FileCountMaxDiscrepancy = -1
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails with negative 'avg_compile_seconds'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.AvgCompileSeconds = -1
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails with negative 'avg_hit_seconds'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.AvgCompileSeconds, c.AvgHitSeconds = 2, -1
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails with 'avg_compile_seconds' without 'avg_hit_seconds'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.AvgCompileSeconds = 2
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails with 'avg_hit_seconds' not less than 'avg_compile_seconds'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.AvgCompileSeconds, c.AvgHitSeconds = 2, 2
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails with invalid 'environment' variable name": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.Environment = map[string]string{"CCACHE_MAXSIZE=": "20G"}
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails in safe mode in exec mode": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.SafeMode = true
+				c.exec = prepareMockVer48()
+			},
+		},
+		"fails in safe mode with an exec source": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.SafeMode = true
+				c.CollectionMode = string(collectionModeSources)
+				c.Sources = []string{sourceStatsFile, sourceTextExec}
+				c.CacheDir = "testdata"
+			},
+		},
+		"fails in safe mode with 'command_wrapper'": {
+			wantFail: true,
+			prepare: func(c *Ccache) {
+				c.SafeMode = true
+				c.CollectionMode = string(collectionModeFile)
+				c.CacheDir = "testdata"
+				c.CommandWrapper = []string{"podman", "unshare"}
+			},
+		},
+		"success in safe mode in file mode": {
+			wantFail: false,
+			prepare: func(c *Ccache) {
+				c.SafeMode = true
+				c.CollectionMode = string(collectionModeFile)
+				c.CacheDir = "testdata"
+			},
+		},
+		"success in file mode without ccache binary": {
+			wantFail: false,
+			prepare: func(c *Ccache) {
+				c.CollectionMode = string(collectionModeFile)
+				c.BinaryPath = ""
+				c.CacheDir = "testdata"
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+
+			test.prepare(c)
+
+			if test.wantFail {
+				assert.False(t, c.Init())
+			} else {
+				assert.True(t, c.Init())
+			}
+		})
+	}
+}
+
+func TestCcache_negotiateStatsFormat(t *testing.T) {
+	tests := map[string]struct {
+		prepare    func() *mockCcacheExec
+		wantFormat statsFormat
+	}{
+		"legacy for ccache 3.4": {
+			prepare:    prepareMockVer34,
+			wantFormat: statsFormatLegacy,
+		},
+		"text for ccache 4.8": {
+			prepare:    prepareMockVer48,
+			wantFormat: statsFormatText,
+		},
+		"json for ccache 4.10": {
+			prepare:    prepareMockVer410,
+			wantFormat: statsFormatJSON,
+		},
+		"legacy for ccache 3.4 if '--help' fails": {
+			prepare: func() *mockCcacheExec {
+				m := prepareMockVer34()
+				m.errOnHelp = true
+				return m
+			},
+			wantFormat: statsFormatLegacy,
+		},
+		"text for ccache 4.10 if '--help' fails": {
+			prepare: func() *mockCcacheExec {
+				m := prepareMockVer410()
+				m.errOnHelp = true
+				return m
+			},
+			wantFormat: statsFormatText,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+			c.exec = test.prepare()
+
+			require.True(t, c.Init())
+
+			assert.Equal(t, test.wantFormat, c.statsFormat)
+		})
+	}
+}
+
+func TestCcache_Collect_StatsCommand(t *testing.T) {
+	tests := map[string]struct {
+		prepare func() *mockCcacheExec
+		wantCmd string
+	}{
+		"'-s' for ccache 2.4": {
+			prepare: prepareMockVer24,
+			wantCmd: "-s",
+		},
+		"'-s' for ccache 2.4 if '--help' fails": {
+			prepare: func() *mockCcacheExec {
+				m := prepareMockVer24()
+				m.errOnHelp = true
+				return m
+			},
+			wantCmd: "-s",
+		},
+		"'--show-stats' for ccache 3.4": {
+			prepare: prepareMockVer34,
+			wantCmd: "--show-stats",
+		},
+		"'--show-stats' for ccache 3.4 if '--help' fails": {
+			prepare: func() *mockCcacheExec {
+				m := prepareMockVer34()
+				m.errOnHelp = true
+				return m
+			},
+			wantCmd: "--show-stats",
+		},
+		"'--print-stats' for ccache 4.8": {
+			prepare: prepareMockVer48,
+			wantCmd: "--print-stats",
+		},
+		"'--print-stats --format=json' for ccache 4.10": {
+			prepare: prepareMockVer410,
+			wantCmd: "--print-stats --format=json",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+			m := test.prepare()
+			c.exec = m
+			require.True(t, c.Init())
+
+			assert.NotNil(t, c.Collect())
+			assert.Equal(t, test.wantCmd, m.statsCmd)
+		})
+	}
+}
+
+func TestCcache_Health(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+
+	h := c.Health()
+	assert.True(t, h.BinaryFound)
+	assert.Equal(t, "4.8.3", h.Version)
+	assert.Equal(t, string(statsFormatText), h.StatsFormat)
+	assert.True(t, h.LastCollectTime.IsZero())
+
+	require.NotNil(t, c.Collect())
+	h = c.Health()
+	assert.True(t, h.LastCollectSuccess)
+	assert.True(t, h.CoreMetricsPresent)
+	assert.Empty(t, h.LastCollectError)
+
+	m.errOnStats = true
+	require.Nil(t, c.Collect())
+	h = c.Health()
+	assert.False(t, h.LastCollectSuccess)
+	assert.False(t, h.CoreMetricsPresent)
+	assert.NotEmpty(t, h.LastCollectError)
+}
+
+func TestCcache_Init_PercentageChartType(t *testing.T) {
+	tests := map[string]struct {
+		chartType string
+		wantType  module.ChartType
+	}{
+		"stacked (default)": {chartType: "", wantType: module.Stacked},
+		"stacked":           {chartType: "stacked", wantType: module.Stacked},
+		"line":              {chartType: "line", wantType: module.Line},
+		"unknown":           {chartType: "pie", wantType: module.Stacked},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+			c.SinceStart = true
+			if test.chartType != "" {
+				c.PercentageChartType = test.chartType
+			}
+			c.exec = prepareMockVer48()
+			require.True(t, c.Init())
+
+			for _, id := range percentageCharts {
+				chart := c.Charts().Get(id)
+				require.NotNilf(t, chart, "chart '%s'", id)
+				assert.Equalf(t, test.wantType, chart.Type, "chart '%s'", id)
+			}
+			assert.Equal(t, module.Stacked, c.Charts().Get(hitsChart.ID).Type, "non percentage charts must not be affected")
+		})
+	}
+}
+
+func TestCcache_Init_Priority(t *testing.T) {
+	c := New()
+	c.Priority = module.Priority + 1000
+	c.SinceStart = true
+	c.exec = prepareMockVer48()
+	require.True(t, c.Init())
+	require.NotNil(t, c.Collect())
+
+	for _, chart := range *c.Charts() {
+		assert.GreaterOrEqualf(t, chart.Priority, prioCcacheHits+1000, "chart '%s' priority is not shifted", chart.ID)
+	}
+	assert.Equal(t, prioCcacheHits+1000, c.Charts().Get(hitsChart.ID).Priority)
+	assert.Equal(t, prioCcacheSinceStartCalls+1000, c.Charts().Get(sinceStartCallsChart.ID).Priority)
+	assert.Equal(t, prioCcacheLocalStorage+1000, c.Charts().Get(localStorageChart.ID).Priority)
+	assert.Equal(t, prioCcacheHits, hitsChart.Priority, "chart templates must not be modified")
+
+	c = New()
+	c.Priority = module.Priority
+	c.exec = prepareMockVer48()
+	require.True(t, c.Init())
+	assert.Equal(t, prioCcacheHits, c.Charts().Get(hitsChart.ID).Priority, "default priority must not shift charts")
+}
+
+func TestCcache_Init_ContextPrefix(t *testing.T) {
+	c := New()
+	c.ContextPrefix = "staging"
+	c.SinceStart = true
+	c.exec = prepareMockVer48()
+	require.True(t, c.Init())
+	require.NotNil(t, c.Collect())
+
+	for _, chart := range *c.Charts() {
+		assert.Truef(t, strings.HasPrefix(chart.Ctx, "staging.ccache."), "chart '%s' context '%s' is not prefixed", chart.ID, chart.Ctx)
+	}
+	assert.Equal(t, "staging.ccache.local_storage", c.Charts().Get(localStorageChart.ID).Ctx)
+	assert.Equal(t, "ccache.local_storage", localStorageChart.Ctx, "chart templates must not be modified")
+
+	for _, prefix := range []string{"staging.", ".staging", "stag ing", "staging-eu", "staging/eu"} {
+		c = New()
+		c.ContextPrefix = prefix
+		c.exec = prepareMockVer48()
+		assert.Falsef(t, c.Init(), "prefix '%s' must be rejected", prefix)
+	}
+
+	c = New()
+	c.ContextPrefix = "eu_west.staging"
+	c.exec = prepareMockVer48()
+	assert.True(t, c.Init())
+}
+
+func TestCcache_Init_TitlePrefixAndFamily(t *testing.T) {
+	c := New()
+	c.TitlePrefix = "Frontend CI cache"
+	c.Family = "frontend ci"
+	c.SinceStart = true
+	c.exec = prepareMockVer48()
+	require.True(t, c.Init())
+	require.NotNil(t, c.Collect())
+
+	for _, chart := range *c.Charts() {
+		assert.Truef(t, strings.HasPrefix(chart.Title, "Frontend CI cache: "), "chart '%s' title '%s' is not prefixed", chart.ID, chart.Title)
+		assert.Equalf(t, "frontend ci", chart.Fam, "chart '%s' family", chart.ID)
+	}
+	assert.Equal(t, "Frontend CI cache: Local Storage Hits/Misses", c.Charts().Get(localStorageChart.ID).Title)
+	assert.Equal(t, "Local Storage Hits/Misses", localStorageChart.Title, "chart templates must not be modified")
+
+	c = New()
+	c.exec = prepareMockVer48()
+	require.True(t, c.Init())
+	assert.Equal(t, hitsChart.Title, c.Charts().Get(hitsChart.ID).Title)
+	assert.Equal(t, hitsChart.Fam, c.Charts().Get(hitsChart.ID).Fam)
+
+	for _, v := range []string{"it's mine", "two\nlines", strings.Repeat("x", maxChartTextLen+1)} {
+		c = New()
+		c.TitlePrefix = v
+		c.exec = prepareMockVer48()
+		assert.Falsef(t, c.Init(), "title prefix %q must be rejected", v)
+
+		c = New()
+		c.Family = v
+		c.exec = prepareMockVer48()
+		assert.Falsef(t, c.Init(), "family %q must be rejected", v)
+	}
+}
+
+func TestCcache_Charts(t *testing.T) {
+	assert.NotNil(t, New().Charts())
+}
+
+func TestCcache_Cleanup(t *testing.T) {
+	assert.NotPanics(t, New().Cleanup)
+}
+
+var reChartContext = regexp.MustCompile(`^ccache\.[a-z0-9_]+$`)
+
+// TestCcache_Lifecycle drives the module the way the agent does (created by the registered creator, configured from
+// yaml, Init -> Check -> Charts -> Collect -> Cleanup through module.Module) against a fake ccache binary.
+func TestCcache_Lifecycle(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	testdata, err := filepath.Abs("testdata")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "ccache")
+	script := fmt.Sprintf(`#!/bin/sh
+case "$1" in
+  --version) cat '%[1]s/version-4.8.txt' ;;
+  --help) cat '%[1]s/help-4.8.txt' ;;
+  --print-stats) cat '%[1]s/print-stats-4.8.txt' ;;
+  *) exit 1 ;;
+esac
+`, testdata)
+	require.NoError(t, os.WriteFile(bin, []byte(script), 0755))
+
+	creator, ok := module.DefaultRegistry["ccache"]
+	require.True(t, ok, "module is not registered")
+
+	var mod module.Module = creator.Create()
+	cfg := fmt.Sprintf("binary_path: '%s'\ncache_dir: '%s'\nsince_start: yes\n", bin, dir)
+	require.NoError(t, yaml.Unmarshal([]byte(cfg), mod))
+
+	require.True(t, mod.Init())
+	require.True(t, mod.Check())
+
+	for i := 0; i < 2; i++ {
+		mx := mod.Collect()
+		require.NotNil(t, mx)
+
+		charts := mod.Charts()
+		require.NotNil(t, charts)
+		require.NotEmpty(t, *charts)
+
+		seen := make(map[string]bool)
+		for _, chart := range *charts {
+			assert.Falsef(t, seen[chart.ID], "duplicate chart id '%s'", chart.ID)
+			seen[chart.ID] = true
+			assert.Regexpf(t, reChartContext, chart.Ctx, "chart '%s' context", chart.ID)
+			assert.NotEmptyf(t, chart.Title, "chart '%s' title", chart.ID)
+			assert.NotEmptyf(t, chart.Units, "chart '%s' units", chart.ID)
+			assert.NotEmptyf(t, chart.Fam, "chart '%s' family", chart.ID)
+			assert.NotEmptyf(t, chart.Dims, "chart '%s' has no dims", chart.ID)
+
+			dims := make(map[string]bool)
+			for _, dim := range chart.Dims {
+				assert.Falsef(t, dims[dim.ID], "chart '%s' duplicate dim '%s'", chart.ID, dim.ID)
+				dims[dim.ID] = true
+				assert.Containsf(t, mx, dim.ID, "chart '%s' dim '%s' has no metric", chart.ID, dim.ID)
+			}
+		}
+	}
+
+	assert.NotPanics(t, mod.Cleanup)
+}
+
+func TestCcache_Check(t *testing.T) {
+	tests := map[string]struct {
+		prepare  func() *mockCcacheExec
+		wantFail bool
+	}{
+		"success with ccache 3.4": {
+			wantFail: false,
+			prepare:  prepareMockVer34,
+		},
+		"success with ccache 4.8": {
+			wantFail: false,
+			prepare:  prepareMockVer48,
+		},
+		"success with ccache 4.10": {
+			wantFail: false,
+			prepare:  prepareMockVer410,
+		},
+		"fails if stats command returns an error": {
+			wantFail: true,
+			prepare: func() *mockCcacheExec {
+				m := prepareMockVer48()
+				m.errOnStats = true
+				return m
+			},
+		},
+		"fails if stats command returns no stats": {
+			wantFail: true,
+			prepare: func() *mockCcacheExec {
+				m := prepareMockVer48()
+				m.printStatsData = []byte("garbage\n")
+				return m
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+			c.exec = test.prepare()
+			require.True(t, c.Init())
+
+			if test.wantFail {
+				assert.False(t, c.Check())
+			} else {
+				assert.True(t, c.Check())
+			}
+		})
+	}
+}
+
+func TestCcache_Collect(t *testing.T) {
+	tests := map[string]struct {
+		prepare       func() *mockCcacheExec
+		wantMetrics   map[string]int64
+		wantNumCharts int
+	}{
+		"ccache 3.4 (legacy format)": {
+			prepare: prepareMockVer34,
+			wantMetrics: map[string]int64{
+				"avg_object_size_bytes":              294835,
+				"bad_compiler_arguments":             13,
+				"cache_hit_percentage":               79001,
+				"cache_miss":                         1300,
+				"cache_miss_percentage":              20998,
+				"cache_size":                         2900000000,
+				"cacheable_call_share":               94605,
+				"called_for_link":                    230,
+				"called_for_preprocessing":           11,
+				"cleanups_performed":                 4,
+				"compile_failed":                     27,
+				"direct_cache_hit":                   4706,
+				"files_in_cache":                     9836,
+				"hit_rate_trend":                     0,
+				"metrics_age_seconds":                0,
+				"preprocessing_call_share":           168,
+				"cache_resets":                       0,
+				"store_retrieve_ratio":               265,
+				"files_added_per_interval":           0,
+				"files_removed_per_interval":         0,
+				"estimated_io_saved_bytes":           0,
+				"collect_errors":                     0,
+				"consecutive_successful_collects":    1,
+				"consecutive_failed_collects":        0,
+				"no_input_file":                      8,
+				"preprocessed_cache_hit":             185,
+				"preprocessor_error":                 6,
+				"recent_bad_compiler_arguments":      0,
+				"recent_cache_hit_percentage":        0,
+				"cache_effective":                    0,
+				"recent_cache_miss_percentage":       0,
+				"recent_called_for_link":             0,
+				"recent_called_for_preprocessing":    0,
+				"recent_no_input_file":               0,
+				"recent_unsupported_compiler_option": 0,
+				"total_calls":                        6544,
+				"unsupported_code_directive":         0,
+				"unsupported_compiler_option":        91,
+			},
+			wantNumCharts: len(baseCharts) + 5,
+		},
+		"ccache 4.8 (text format)": {
+			prepare:       prepareMockVer48,
+			wantMetrics:   expectedVer4Metrics,
+			wantNumCharts: len(baseCharts) + 11,
+		},
+		"ccache 4.8 (text format with header/footer lines)": {
+			prepare: func() *mockCcacheExec {
+				m := prepareMockVer48()
+				m.printStatsData = dataVer48PrintStatsDecorated
+				return m
+			},
+			wantMetrics:   expectedVer4Metrics,
+			wantNumCharts: len(baseCharts) + 11,
+		},
+		"ccache 4.10 (json format)": {
+			prepare:       prepareMockVer410,
+			wantMetrics:   expectedVer4Metrics,
+			wantNumCharts: len(baseCharts) + 11,
+		},
+		"fails if stats command returns an error": {
+			prepare: func() *mockCcacheExec {
+				m := prepareMockVer48()
+				m.errOnStats = true
+				return m
+			},
+			wantNumCharts: len(baseCharts),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+			c.exec = test.prepare()
+			require.True(t, c.Init())
+
+			mx := c.Collect()
+
+			assert.Equal(t, test.wantMetrics, mx)
+			assert.Len(t, *c.Charts(), test.wantNumCharts)
+			testMetricsHasAllChartsDims(t, c, mx)
+		})
+	}
+}
+
+func TestCcache_Collect_Sources(t *testing.T) {
+	stats, err := parseStatsText(dataVer48PrintStats)
+	require.NoError(t, err)
+	stats["cache_miss"] = 1400
+
+	dir := t.TempDir()
+	writeStatsFile(t, filepath.Join(dir, "0", "stats"), stats)
+
+	c := New()
+	c.CollectionMode = string(collectionModeSources)
+	c.Sources = []string{sourceJSONExec, sourceTextExec, sourceStatsFile}
+	c.CacheDir = dir
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1300), mx["cache_miss"])
+	assert.Equal(t, sourceTextExec, c.Health().ActiveSource, "json is not supported by 4.8, must fall back to text")
+
+	m.errOnStats = true
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1400), mx["cache_miss"])
+	assert.Equal(t, sourceStatsFile, c.Health().ActiveSource)
+
+	c.CacheDir, c.cacheDir = "", filepath.Join(dir, "missing")
+	assert.Nil(t, c.Collect(), "must fail if all sources fail")
+	assert.Empty(t, c.Health().ActiveSource)
+}
+
+func TestCcache_Collect_MirrorSource(t *testing.T) {
+	mirror := filepath.Join(t.TempDir(), "ccache-stats.txt")
+	require.NoError(t, os.WriteFile(mirror, dataVer48PrintStats, 0644))
+
+	stats, err := parseStatsText(dataVer48PrintStats)
+	require.NoError(t, err)
+	stats["cache_miss"] = 1400
+	dir := t.TempDir()
+	writeStatsFile(t, filepath.Join(dir, "0", "stats"), stats)
+
+	c := New()
+	c.CollectionMode = string(collectionModeSources)
+	c.Sources = []string{sourceMirror, sourceStatsFile}
+	c.Mirror = mirror
+	c.MirrorMaxAge = web.Duration{Duration: time.Hour}
+	c.CacheDir = dir
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, sourceMirror, c.Health().ActiveSource)
+	assert.Equal(t, int64(1300), mx["cache_miss"])
+	assert.Equal(t, int64(0), mx["mirror_age_seconds"])
+	assert.NotNil(t, c.Charts().Get(mirrorAgeChart.ID))
+
+	// the mirror replication lags
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(mirror, old, old))
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, sourceStatsFile, c.Health().ActiveSource, "a stale mirror must fall back to the next source")
+	assert.Equal(t, int64(1400), mx["cache_miss"])
+	assert.NotContains(t, mx, "mirror_age_seconds")
+	assert.True(t, c.mirrorStale)
+
+	c.MirrorMaxAge = web.Duration{}
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, sourceMirror, c.Health().ActiveSource, "the freshness check is optional")
+	assert.InDelta(t, 2*time.Hour.Seconds(), mx["mirror_age_seconds"], 5)
+
+	c.Sources, c.sources = []string{sourceMirror}, c.sources[:1]
+	c.MirrorMaxAge = web.Duration{Duration: time.Hour}
+	_, err = c.collect()
+	assert.ErrorIs(t, err, errStaleMirror, "a stale mirror as the only source must fail clearly")
+}
+
+func TestCcache_Collect_MirrorSourceURL(t *testing.T) {
+	lastModified := time.Now().Add(-time.Minute)
+	withHeader := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if withHeader {
+			w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		}
+		_, _ = w.Write(dataVer410PrintStatsJSON)
+	}))
+	defer srv.Close()
+
+	c := New()
+	c.CollectionMode = string(collectionModeSources)
+	c.Sources = []string{sourceMirror}
+	c.Mirror = srv.URL
+	c.MirrorMaxAge = web.Duration{Duration: time.Hour}
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.InDelta(t, time.Minute.Seconds(), mx["mirror_age_seconds"], 5)
+
+	withHeader = false
+	_, err := c.collect()
+	assert.ErrorIs(t, err, errStaleMirror, "the freshness of a mirror without 'Last-Modified' can't be checked")
+
+	c.MirrorMaxAge = web.Duration{}
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.NotContains(t, mx, "mirror_age_seconds", "the mirror age is unknown")
+}
+
+func TestCcache_Collect_CallsPerBuild(t *testing.T) {
+	t.Setenv("CI_BUILD_COUNT", "")
+
+	c := New()
+	c.BuildCountEnv = "CI_BUILD_COUNT"
+	m := prepareMockVer48()
+	m.printStatsData = []byte("direct_cache_hit\t300\ncache_miss\t100\n")
+	c.exec = m
+	require.True(t, c.Init())
+	require.NotNil(t, c.Charts().Get(callsPerBuildChart.ID))
+
+	mx := c.Collect()
+	require.NotNil(t, mx, "a missing build count must not fail the collection")
+	assert.NotContains(t, mx, "hits_per_build")
+	assert.True(t, c.buildCountFailed)
+
+	t.Setenv("CI_BUILD_COUNT", "40")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(7500), mx["hits_per_build"])
+	assert.Equal(t, int64(2500), mx["misses_per_build"])
+
+	c.BuildCountFile = filepath.Join(t.TempDir(), "builds")
+	require.NoError(t, os.WriteFile(c.BuildCountFile, []byte("not a number"), 0644))
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.NotContains(t, mx, "hits_per_build", "the build count file takes precedence")
+
+	require.NoError(t, os.WriteFile(c.BuildCountFile, []byte("100\n"), 0644))
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(3000), mx["hits_per_build"])
+}
+
+func TestCcache_Collect_Sloppiness(t *testing.T) {
+	c := New()
+	c.SloppinessProbe = true
+	m := prepareMockVer48()
+	m.printConfigData = dataVer48ShowConfig
+	c.exec = m
+	require.True(t, c.Init())
+	require.NotNil(t, c.Charts().Get(sloppinessChart.ID))
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(3), mx["sloppiness_flags"])
+
+	for _, chart := range *c.Charts() {
+		var value string
+		for _, lbl := range chart.Labels {
+			if lbl.Key == "sloppiness" {
+				value = lbl.Value
+			}
+		}
+		assert.Equalf(t, "include_file_mtime,pch_defines,time_macros", value, "chart '%s'", chart.ID)
+	}
+}
+
+func TestCcache_Collect_SloppinessUnavailable(t *testing.T) {
+	tests := map[string]func(c *Ccache, m *mockCcacheExec){
+		"probe disabled": func(c *Ccache, m *mockCcacheExec) { m.printConfigData = dataVer48ShowConfig },
+		"'-p' fails":     func(c *Ccache, m *mockCcacheExec) { c.SloppinessProbe = true },
+		"no sloppiness in the output": func(c *Ccache, m *mockCcacheExec) {
+			c.SloppinessProbe = true
+			m.printConfigData = []byte("(default) max_size = 5.0G\n")
+		},
+	}
+
+	for name, prepare := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+			m := prepareMockVer48()
+			prepare(c, m)
+			c.exec = m
+			require.True(t, c.Init(), "the probe failure must not fail the job")
+			assert.Nil(t, c.Charts().Get(sloppinessChart.ID))
+
+			mx := c.Collect()
+			require.NotNil(t, mx)
+			assert.NotContains(t, mx, "sloppiness_flags")
+			for _, chart := range *c.Charts() {
+				for _, lbl := range chart.Labels {
+					assert.NotEqualf(t, "sloppiness", lbl.Key, "chart '%s'", chart.ID)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseSloppiness(t *testing.T) {
+	tests := map[string]struct {
+		input  string
+		want   []string
+		wantOK bool
+	}{
+		"ccache 4.x": {
+			input:  string(dataVer48ShowConfig),
+			want:   []string{"include_file_mtime", "pch_defines", "time_macros"},
+			wantOK: true,
+		},
+		"ccache 3.x, space separated and duplicated": {
+			input:  "(environment) sloppiness = file_macro time_macros,  file_macro\n",
+			want:   []string{"file_macro", "time_macros"},
+			wantOK: true,
+		},
+		"none enabled": {
+			input:  "(default) sloppiness =\n",
+			want:   []string{},
+			wantOK: true,
+		},
+		"no sloppiness": {
+			input: "(default) max_size = 5.0G\n",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			flags, ok := parseSloppiness([]byte(test.input))
+			assert.Equal(t, test.wantOK, ok)
+			assert.Equal(t, test.want, flags)
+		})
+	}
+}
+
+func TestCcache_Collect_CacheEffective(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	m.printStatsData = []byte("direct_cache_hit\t10\ncache_miss\t10\n")
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["cache_effective"], "the first collection has no interval")
+
+	m.printStatsData = []byte("direct_cache_hit\t17\ncache_miss\t13\n")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1), mx["cache_effective"])
+
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["cache_effective"], "an idle cache must not be effective")
+
+	c.EffectiveHitRateThreshold = 80
+	m.printStatsData = []byte("direct_cache_hit\t24\ncache_miss\t16\n")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["cache_effective"], "the hit ratio must exceed the threshold")
+}
+
+func TestCcache_Collect_RecentStats(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["recent_cache_hit_percentage"])
+	assert.Equal(t, int64(0), mx["recent_called_for_link"])
+
+	m.printStatsData = []byte(strings.NewReplacer(
+		"\ndirect_cache_hit\t4706\n", "\ndirect_cache_hit\t4736\n",
+		"\ncache_miss\t1300\n", "\ncache_miss\t1310\n",
+		"\ncalled_for_link\t230\n", "\ncalled_for_link\t235\n",
+	).Replace(string(dataVer48PrintStats)))
+
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(75000), mx["recent_cache_hit_percentage"])
+	assert.Equal(t, int64(25000), mx["recent_cache_miss_percentage"])
+	assert.NotContains(t, mx, "recent_cache_uncacheable_percentage")
+	assert.Equal(t, int64(5), mx["recent_called_for_link"])
+	assert.Equal(t, int64(0), mx["recent_no_input_file"])
+}
+
+func TestCcache_Collect_EvictionPressure(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+
+	steps := []struct {
+		cleanups, writes int64
+		want             int64
+	}{
+		{cleanups: 4, writes: 100, want: 0},     // no previous stats
+		{cleanups: 4, writes: 100, want: 0},     // no writes
+		{cleanups: 6, writes: 120, want: 100},   // 2 cleanups per 20 writes
+		{cleanups: 16, writes: 130, want: 1000}, // 10 cleanups per 10 writes
+	}
+
+	for i, step := range steps {
+		m.printStatsData = []byte(fmt.Sprintf("cache_miss\t1\ncleanups_performed\t%d\nlocal_storage_write\t%d\n",
+			step.cleanups, step.writes))
+		mx := c.Collect()
+		require.NotNilf(t, mx, "step %d", i)
+		assert.Equalf(t, step.want, mx["cleanup_per_write_ratio"], "step %d", i)
+	}
+	assert.NotNil(t, c.Charts().Get(evictionPressureChart.ID))
+
+	c = New()
+	c.exec = prepareMockVer34()
+	require.True(t, c.Init())
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.NotContains(t, mx, "cleanup_per_write_ratio", "must be skipped without the writes counter")
+	assert.Nil(t, c.Charts().Get(evictionPressureChart.ID))
+}
+
+func TestCcache_Collect_FirstCollectAddsAllCharts(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	m.printStatsData = dataVer48PrintStats
+	c.exec = m
+	require.True(t, c.Init())
+
+	require.NotNil(t, c.Collect())
+
+	for _, chart := range []module.Chart{
+		missesByModeChart,
+		localStorageChart,
+		remoteStorageChart,
+		remoteStorageErrorsChart,
+		remoteStorageTimeoutShareChart,
+		evictionPressureChart,
+	} {
+		assert.Truef(t, c.Charts().Has(chart.ID), "chart '%s' is not added by the first collection", chart.ID)
+	}
+
+	num := len(*c.Charts())
+	require.NotNil(t, c.Collect())
+	assert.Len(t, *c.Charts(), num, "the subsequent collections must not add charts")
+}
+
+func TestCcache_Collect_ClampsPercentages(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	m.printStatsData = []byte(strings.NewReplacer(
+		"remote_storage_timeout\t1\n", "remote_storage_timeout\t10000\n",
+		"direct_cache_hit\t4706", "direct_cache_hit\t-10000",
+	).Replace(string(dataVer48PrintStats)))
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(100*precision), mx["remote_timeout_share"])
+	for _, chart := range *c.Charts() {
+		if chart.Units != "percentage" {
+			continue
+		}
+		for _, dim := range chart.Dims {
+			if v, ok := mx[dim.ID]; ok {
+				assert.GreaterOrEqualf(t, v, int64(0), "dim '%s'", dim.ID)
+				assert.LessOrEqualf(t, v, int64(100*precision), "dim '%s'", dim.ID)
+			}
+		}
+	}
+}
+
+func Test_clampPercentage(t *testing.T) {
+	tests := map[string]struct {
+		value, want int64
+	}{
+		"negative":     {value: -1, want: 0},
+		"zero":         {value: 0, want: 0},
+		"in range":     {value: 55 * precision, want: 55 * precision},
+		"hundred":      {value: 100 * precision, want: 100 * precision},
+		"above":        {value: 100*precision + 1, want: 100 * precision},
+		"far above":    {value: 1 << 40, want: 100 * precision},
+		"far negative": {value: -(1 << 40), want: 0},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.want, clampPercentage(test.value))
+		})
+	}
+}
+
+func TestCcache_Collect_StoreRetrieveRatio(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	m.printStatsData = []byte(strings.NewReplacer(
+		"direct_cache_hit\t4706", "direct_cache_hit\t0",
+		"preprocessed_cache_hit\t185", "preprocessed_cache_hit\t0",
+	).Replace(string(dataVer48PrintStats)))
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["store_retrieve_ratio"], "no retrieved objects")
+
+	m.printStatsData = []byte(strings.NewReplacer(
+		"direct_cache_hit\t4706", "direct_cache_hit\t5200",
+	).Replace(string(dataVer48PrintStats)))
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(2600*precision/(5200+185)), mx["store_retrieve_ratio"])
+}
+
+func TestCcache_Collect_StatLatency(t *testing.T) {
+	c := New()
+	c.exec = prepareMockVer48()
+	c.CacheDir = t.TempDir()
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.NotContains(t, mx, "cache_dir_stat_latency_ms", "the probe is opt-in")
+	assert.False(t, c.Charts().Has(statLatencyChart.ID))
+
+	c = New()
+	c.exec = prepareMockVer48()
+	c.CacheDir = t.TempDir()
+	c.StatLatencyProbe = true
+	require.True(t, c.Init())
+
+	for i := 0; i < 2; i++ {
+		mx = c.Collect()
+		require.NotNil(t, mx)
+		assert.Contains(t, mx, "cache_dir_stat_latency_ms")
+		assert.GreaterOrEqual(t, mx["cache_dir_stat_latency_ms"], int64(0))
+	}
+	assert.True(t, c.Charts().Has(statLatencyChart.ID))
+	assert.Equal(t, 2, c.statProbes)
+	entries, err := os.ReadDir(c.CacheDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the probe must not modify the cache directory")
+
+	c = New()
+	c.URL = "http://127.0.0.1:38001"
+	c.StatLatencyProbe = true
+	require.True(t, c.Init())
+	assert.False(t, c.StatLatencyProbe, "the probe needs a local cache directory")
+}
+
+func TestCcache_Collect_UnsupportedOptions(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	m.printStatsData = []byte("direct_cache_hit\t10\ncache_miss\t10\n")
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Nil(t, c.Charts().Get(unsupportedOptionsChart.ID), "chart must not be added without the unsupported options counters")
+
+	m.printStatsData = []byte("direct_cache_hit\t10\ncache_miss\t10\nunsupported_compiler_option\t7\n")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.NotNil(t, c.Charts().Get(unsupportedOptionsChart.ID))
+	assert.Equal(t, int64(7), mx["unsupported_compiler_option"])
+	assert.Equal(t, int64(0), mx["unsupported_code_directive"], "the absent counter defaults to zero")
+	assert.True(t, c.Charts().Get(uncacheableCallsChart.ID).HasDim("unsupported_compiler_option"),
+		"the uncacheable calls chart keeps the counter")
+}
+
+func TestCcache_Collect_SuppressMetrics(t *testing.T) {
+	ref := New()
+	ref.exec = prepareMockVer48()
+	require.True(t, ref.Init())
+	want := ref.Collect()
+	require.NotNil(t, want)
+
+	c := New()
+	c.SuppressMetrics = []string{"direct_cache_hit", "cache_size", "called_for_link", "no_such_metric"}
+	c.exec = prepareMockVer48()
+	require.True(t, c.Init(), "unknown entries must not fail the job")
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	for _, id := range c.SuppressMetrics {
+		assert.NotContainsf(t, mx, id, "suppressed metric '%s'", id)
+	}
+	for _, chart := range *c.Charts() {
+		for _, id := range c.SuppressMetrics {
+			assert.Falsef(t, chart.HasDim(id), "chart '%s' has suppressed dim '%s'", chart.ID, id)
+		}
+	}
+	assert.Nil(t, c.Charts().Get(cacheSizeChart.ID), "a chart with all dims suppressed must not be added")
+	assert.NotNil(t, c.Charts().Get(hitsChart.ID))
+
+	// the derived metrics still use the suppressed values
+	for _, id := range []string{"cache_hit_percentage", "avg_object_size_bytes", "cacheable_call_share"} {
+		assert.Equalf(t, want[id], mx[id], "derived metric '%s'", id)
+	}
+	testMetricsHasAllChartsDims(t, c, mx)
+}
+
+func Test_fitGrowthRate(t *testing.T) {
+	start := time.Now()
+	samples := func(sizes ...int64) []sizeSample {
+		var ss []sizeSample
+		for i, size := range sizes {
+			ss = append(ss, sizeSample{time: start.Add(time.Duration(i) * time.Hour), size: size})
+		}
+		return ss
+	}
+
+	_, ok := fitGrowthRate(samples(100))
+	assert.False(t, ok, "a single sample")
+	_, ok = fitGrowthRate([]sizeSample{{time: start, size: 1}, {time: start.Add(time.Minute), size: 2}})
+	assert.False(t, ok, "too short span")
+
+	v, ok := fitGrowthRate(samples(1000, 2000, 3000, 4000))
+	require.True(t, ok)
+	assert.Equal(t, int64(24*1000), v)
+
+	// a cleanup drop after steady growth: the fit stays far above the raw delta of the last interval (-3500/hour)
+	v, ok = fitGrowthRate(samples(1000, 2000, 3000, 4000, 5000, 1500))
+	require.True(t, ok)
+	assert.Greater(t, v, int64(-3500*24))
+	assert.Less(t, v, int64(24*1000))
+}
+
+func TestCcache_checkProjectedFill(t *testing.T) {
+	c := New()
+	c.cacheMaxSize = 10_000
+	c.cacheGrowth = 1000
+
+	c.checkProjectedFill(1000)
+	assert.False(t, c.fillWarned, "9 days away")
+	c.checkProjectedFill(5000)
+	assert.True(t, c.fillWarned, "5 days away")
+
+	c.cacheGrowth = 0
+	c.checkProjectedFill(5000)
+	assert.False(t, c.fillWarned, "not growing")
+}
+
+func Test_parseCcacheSize(t *testing.T) {
+	tests := map[string]struct {
+		want   int64
+		wantOK bool
+	}{
+		"5G":    {want: 5e9, wantOK: true},
+		"500M":  {want: 500e6, wantOK: true},
+		"2Gi":   {want: 2 << 30, wantOK: true},
+		"1.5":   {want: 1.5e9, wantOK: true},
+		"10 k":  {want: 10e3, wantOK: true},
+		"0":     {want: 0, wantOK: true},
+		"":      {},
+		"lots":  {},
+		"-1G":   {},
+		"1.5Ti": {want: 3 << 39, wantOK: true},
+	}
+
+	for in, test := range tests {
+		t.Run(in, func(t *testing.T) {
+			v, ok := parseCcacheSize(in)
+			assert.Equal(t, test.wantOK, ok)
+			assert.Equal(t, test.want, v)
+		})
+	}
+}
+
+func TestCcache_Collect_EstimatedIOSaved(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["estimated_io_saved_bytes"], "nothing is saved before the first interval")
+
+	m.printStatsData = []byte(strings.Replace(string(dataVer48PrintStats),
+		"direct_cache_hit\t4706", "direct_cache_hit\t4716", 1))
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, 10*mx["avg_object_size_bytes"], mx["estimated_io_saved_bytes"])
+	saved := mx["estimated_io_saved_bytes"]
+
+	m.printStatsData = []byte(strings.NewReplacer(
+		"direct_cache_hit\t4706", "direct_cache_hit\t4726",
+		"files_in_cache\t9836", "files_in_cache\t0",
+	).Replace(string(dataVer48PrintStats)))
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, saved, mx["estimated_io_saved_bytes"], "no estimate without files in cache")
+}
+
+func TestCcache_Collect_CacheChurn(t *testing.T) {
+	type step struct {
+		files, cleanups, writes int64
+		wantAdded, wantRemoved  int64
+	}
+	tests := map[string]struct {
+		noWrites bool
+		steps    []step
+	}{
+		"with local storage writes": {
+			steps: []step{
+				{files: 100, cleanups: 1, writes: 100},
+				{files: 130, cleanups: 1, writes: 130, wantAdded: 30},
+				{files: 90, cleanups: 2, writes: 150, wantAdded: 20, wantRemoved: 60},
+				{files: 0, cleanups: 2, writes: 150},
+			},
+		},
+		"without local storage writes": {
+			noWrites: true,
+			steps: []step{
+				{files: 100, cleanups: 1},
+				{files: 130, cleanups: 1, wantAdded: 30},
+				{files: 90, cleanups: 2, wantRemoved: 40},
+				{files: 0, cleanups: 2},
+			},
+		},
+		"stats zeroed": {
+			steps: []step{
+				{files: 100, cleanups: 5, writes: 100},
+				{files: 50, cleanups: 0, writes: 0},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+			m := prepareMockVer48()
+			c.exec = m
+			require.True(t, c.Init())
+
+			for i, s := range test.steps {
+				data := fmt.Sprintf("direct_cache_hit\t1\nfiles_in_cache\t%d\ncleanups_performed\t%d\n", s.files, s.cleanups)
+				if !test.noWrites {
+					data += fmt.Sprintf("local_storage_write\t%d\n", s.writes)
+				}
+				m.printStatsData = []byte(data)
+
+				mx := c.Collect()
+				require.NotNilf(t, mx, "step %d", i)
+				assert.Equalf(t, s.wantAdded, mx["files_added_per_interval"], "step %d added", i)
+				assert.Equalf(t, s.wantRemoved, mx["files_removed_per_interval"], "step %d removed", i)
+			}
+		})
+	}
+}
+
+func TestCcache_Collect_CacheableCallShare(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	m.printStatsData = []byte("files_in_cache\t10\n")
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["cacheable_call_share"], "no calls")
+
+	m.printStatsData = []byte("direct_cache_hit\t30\ncache_miss\t10\ncalled_for_link\t60\n")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(40*precision), mx["cacheable_call_share"])
+}
+
+func TestCcache_Collect_PercentageChartsScale(t *testing.T) {
+	// the last interval and since start: 75 hits, 20 misses, 5 uncacheable calls
+	tests := map[string]struct {
+		basis hitRateBasis
+		want  map[string]float64
+	}{
+		"hits_misses basis": {
+			basis: hitRateBasisHitsMisses,
+			want:  map[string]float64{"hit": 78.947, "miss": 21.052},
+		},
+		"all_calls basis": {
+			basis: hitRateBasisAllCalls,
+			want:  map[string]float64{"hit": 75, "miss": 20, "uncacheable": 5},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+			c.SinceStart = true
+			c.HitRateBasis = string(test.basis)
+			m := prepareMockVer48()
+			c.exec = m
+			require.True(t, c.Init())
+
+			statsData := func(hits, misses, uncacheable int64) []byte {
+				return []byte(fmt.Sprintf("direct_cache_hit\t%d\npreprocessed_cache_hit\t%d\ncache_miss\t%d\ncalled_for_link\t%d\n",
+					hits-hits/3, hits/3, misses, uncacheable))
+			}
+
+			m.printStatsData = statsData(0, 0, 0)
+			require.NotNil(t, c.Collect())
+			m.printStatsData = statsData(75, 20, 5)
+			mx := c.Collect()
+			require.NotNil(t, mx)
+
+			for _, id := range percentageCharts {
+				chart := c.Charts().Get(id)
+				require.NotNilf(t, chart, "chart '%s'", id)
+				require.Lenf(t, chart.Dims, len(test.want), "chart '%s'", id)
+
+				var sum float64
+				for _, dim := range chart.Dims {
+					v, ok := mx[dim.ID]
+					require.Truef(t, ok, "chart '%s' dim '%s' is not collected", id, dim.ID)
+
+					mul, div := float64(max(dim.Mul, 1)), float64(max(dim.Div, 1))
+					assert.InDeltaf(t, test.want[dim.Name], float64(v)*mul/div, 0.01,
+						"chart '%s' dim '%s' displayed percentage", id, dim.ID)
+					sum += float64(v) * mul / div
+				}
+				assert.InDeltaf(t, 100, sum, 0.01, "chart '%s' percentages must add up to 100", id)
+			}
+		})
+	}
+}
+
+func TestCcache_Collect_HitRateTrend(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+
+	steps := []struct {
+		hits, misses int64
+		wantTrend    int64
+	}{
+		{hits: 0, misses: 0, wantTrend: 0},        // no previous stats
+		{hits: 50, misses: 50, wantTrend: 0},      // 50%, no previous window
+		{hits: 130, misses: 70, wantTrend: 1},     // 80%
+		{hits: 130, misses: 70, wantTrend: 0},     // idle
+		{hits: 209, misses: 91, wantTrend: 0},     // 79%, within the dead band
+		{hits: 259, misses: 141, wantTrend: -1},   // 50%
+		{hits: 300, misses: 200, wantTrend: -1},   // 41%
+		{hits: 382, misses: 218, wantTrend: 1},    // 82%
+		{hits: 1000, misses: 1000, wantTrend: -1}, // ~44%
+	}
+
+	for i, step := range steps {
+		m.printStatsData = []byte(fmt.Sprintf("direct_cache_hit\t%d\ncache_miss\t%d\n", step.hits, step.misses))
+		mx := c.Collect()
+		require.NotNilf(t, mx, "step %d", i)
+		assert.Equalf(t, step.wantTrend, mx["hit_rate_trend"], "step %d", i)
+	}
+}
+
+func TestCcache_Collect_SinceStart(t *testing.T) {
+	c := New()
+	c.SinceStart = true
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+	require.NotNil(t, c.Charts().Get(sinceStartCallsChart.ID))
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["since_start_cache_miss"])
+	assert.Equal(t, int64(4706), mx["direct_cache_hit"], "lifetime counters must be kept")
+
+	m.printStatsData = []byte(strings.NewReplacer(
+		"\ndirect_cache_hit\t4706\n", "\ndirect_cache_hit\t4736\n",
+		"\ncache_miss\t1300\n", "\ncache_miss\t1310\n",
+	).Replace(string(dataVer48PrintStats)))
+	require.NotNil(t, c.Collect())
+
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(30), mx["since_start_direct_cache_hit"])
+	assert.Equal(t, int64(10), mx["since_start_cache_miss"])
+	assert.Equal(t, int64(75*precision), mx["since_start_cache_hit_percentage"])
+	testMetricsHasAllChartsDims(t, c, mx)
+
+	// zeroed stats ('ccache -z')
+	m.printStatsData = []byte("direct_cache_hit\t2\ncache_miss\t1\n")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["since_start_direct_cache_hit"])
+	assert.Equal(t, int64(0), mx["since_start_cache_miss"])
+	assert.Equal(t, int64(2), c.baseStats["direct_cache_hit"], "must re-baseline")
+}
+
+func TestCcache_Collect_FileMode(t *testing.T) {
+	dir := t.TempDir()
+	writeStatsFile(t, filepath.Join(dir, "0", "stats"), map[string]int64{
+		"direct_cache_hit": 30, "preprocessed_cache_hit": 5, "cache_miss": 10, "called_for_link": 2,
+		"files_in_cache": 100, "cache_size_kibibyte": 1000,
+	})
+	writeStatsFile(t, filepath.Join(dir, "f", "stats"), map[string]int64{
+		"direct_cache_hit": 10, "cache_miss": 5, "files_in_cache": 50, "cache_size_kibibyte": 500,
+	})
+
+	c := New()
+	c.CollectionMode = string(collectionModeFile)
+	c.CacheDir = dir
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+
+	assert.Equal(t, int64(40), mx["direct_cache_hit"])
+	assert.Equal(t, int64(5), mx["preprocessed_cache_hit"])
+	assert.Equal(t, int64(15), mx["cache_miss"])
+	assert.Equal(t, int64(2), mx["called_for_link"])
+	assert.Equal(t, int64(150), mx["files_in_cache"])
+	assert.Equal(t, int64(1500*1024), mx["cache_size"])
+	assert.Equal(t, int64(62), mx["total_calls"])
+	assert.Equal(t, int64(75000), mx["cache_hit_percentage"])
+	testMetricsHasAllChartsDims(t, c, mx)
+}
+
+func TestCcache_Collect_FileModeSymlinkedCacheDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks requires privileges on windows")
+	}
+	volume := t.TempDir()
+	writeStatsFile(t, filepath.Join(volume, "0", "stats"), map[string]int64{
+		"direct_cache_hit": 30, "cache_miss": 10, "files_in_cache": 100, "cache_size_kibibyte": 1000,
+	})
+	link := filepath.Join(t.TempDir(), "ccache")
+	require.NoError(t, os.Symlink(volume, link))
+	t.Setenv("CCACHE_DIR", link)
+
+	c := New()
+	c.CollectionMode = string(collectionModeFile)
+	c.ShardBalance = true
+	require.True(t, c.Init())
+
+	wantDir, err := filepath.EvalSymlinks(volume)
+	require.NoError(t, err)
+	assert.Equal(t, wantDir, c.cacheDir, "the cache dir symlink must be resolved")
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(30), mx["direct_cache_hit"])
+	assert.Equal(t, int64(100), mx["files_in_cache"])
+}
+
+func TestCcache_Collect_FileModeStatsFilesScanned(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"0", "1", "2"} {
+		writeStatsFile(t, filepath.Join(dir, sub, "stats"), map[string]int64{"direct_cache_hit": 1})
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "3"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "3", "stats"), []byte("not stats"), 0644))
+
+	c := New()
+	c.CollectionMode = string(collectionModeFile)
+	c.CacheDir = dir
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(3), mx["stats_files_scanned"])
+	assert.Equal(t, int64(1), mx["stats_files_read_errors"])
+	testMetricsHasAllChartsDims(t, c, mx)
+
+	c.readFile = func(name string) ([]byte, error) {
+		if filepath.Base(filepath.Dir(name)) == "0" {
+			return nil, os.ErrPermission
+		}
+		return os.ReadFile(name)
+	}
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(2), mx["stats_files_scanned"])
+	assert.Equal(t, int64(2), mx["stats_files_read_errors"])
+
+	c = New()
+	c.exec = prepareMockVer48()
+	require.True(t, c.Init())
+	assert.False(t, c.Charts().Has(statsFilesChart.ID), "the chart is added only in file mode")
+	assert.NotContains(t, c.Collect(), "stats_files_scanned")
+}
+
+func TestCcache_Collect_FileModeTornRead(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"0", "1", "2"} {
+		writeStatsFile(t, filepath.Join(dir, sub, "stats"), map[string]int64{"direct_cache_hit": 10, "cache_miss": 5})
+	}
+
+	c := New()
+	c.CollectionMode = string(collectionModeFile)
+	c.CacheDir = dir
+	require.True(t, c.Init())
+
+	// torn cuts the file in the middle of a line, like a read racing a non-atomic writer
+	torn := func(bs []byte, n int) []byte {
+		cut := len(bs) / n
+		for cut > 0 && bs[cut-1] == '\n' {
+			cut--
+		}
+		return bs[:cut]
+	}
+	reads := make(map[string]int)
+	c.readFile = func(name string) ([]byte, error) {
+		reads[name]++
+		bs, err := os.ReadFile(name)
+		switch filepath.Base(filepath.Dir(name)) {
+		case "0":
+			// torn by a concurrent update, clean on the retry
+			if reads[name] == 1 {
+				return torn(bs, 2), err
+			}
+		case "1":
+			// being rewritten during both reads
+			return torn(bs, 3), err
+		}
+		return bs, err
+	}
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(20), mx["direct_cache_hit"], "the torn file must not contribute a part of its counters")
+	assert.Equal(t, int64(10), mx["cache_miss"])
+	assert.Equal(t, int64(2), mx["stats_files_scanned"])
+	assert.Equal(t, int64(1), mx["stats_files_read_errors"])
+	for name, n := range reads {
+		assert.LessOrEqualf(t, n, 2, "'%s' must be retried once at most", name)
+	}
+}
+
+func TestCcache_Collect_FileModeTimeout(t *testing.T) {
+	dir := t.TempDir()
+	writeStatsFile(t, filepath.Join(dir, "0", "stats"), map[string]int64{"direct_cache_hit": 1})
+
+	c := New()
+	c.CollectionMode = string(collectionModeFile)
+	c.CacheDir = dir
+	c.Timeout.Duration = time.Millisecond * 50
+	c.readFile = func(name string) ([]byte, error) {
+		time.Sleep(time.Second) // slow (e.g. NFS mounted) cache dir
+		return os.ReadFile(name)
+	}
+	require.True(t, c.Init())
+
+	start := time.Now()
+	assert.Nil(t, c.Collect())
+	assert.Less(t, time.Since(start), time.Millisecond*500, "reading stats files must respect 'timeout'")
+}
+
+func TestCcache_Collect_ShardBalance(t *testing.T) {
+	dir := t.TempDir()
+	writeStatsFile(t, filepath.Join(dir, "0", "stats"), map[string]int64{"direct_cache_hit": 1})
+	for i, shard := range cacheShards {
+		n := 2
+		if i == 0 {
+			n = 34
+		}
+		for j := 0; j < n; j++ {
+			path := filepath.Join(dir, shard, fmt.Sprintf("%x", j%16), fmt.Sprintf("%dR", j))
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, os.WriteFile(path, nil, 0644))
+		}
+	}
+
+	c := New()
+	c.CollectionMode = string(collectionModeFile)
+	c.CacheDir = dir
+	c.ShardBalance = true
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(2), mx["shard_files_min"])
+	assert.Equal(t, int64(34), mx["shard_files_max"])
+	assert.Equal(t, int64(7745), mx["shard_files_stddev"])
+	testMetricsHasAllChartsDims(t, c, mx)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1", "new"), nil, 0644))
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(2), mx["shard_files_min"], "shards files counting must be rate-limited")
+}
+
+func TestCcache_Collect_FileCountDiscrepancy(t *testing.T) {
+	dir := t.TempDir()
+	writeStatsFile(t, filepath.Join(dir, "0", "stats"), map[string]int64{"direct_cache_hit": 1, "files_in_cache": 10})
+	for j := 0; j < 34; j++ {
+		path := filepath.Join(dir, cacheShards[j%len(cacheShards)], "0", fmt.Sprintf("%dR", j))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, nil, 0644))
+	}
+
+	c := New()
+	c.CollectionMode = string(collectionModeFile)
+	c.CacheDir = dir
+	c.FileCountCheck = true
+	require.True(t, c.Init())
+	require.NotNil(t, c.Charts().Get(fileCountDiscrepancyChart.ID))
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(10), mx["files_in_cache"])
+	assert.Equal(t, int64(24), mx["file_count_discrepancy"])
+	assert.True(t, c.fileCountWarned, "a discrepancy above 'file_count_max_discrepancy' must be warned about")
+
+	writeStatsFile(t, filepath.Join(dir, "0", "stats"), map[string]int64{"direct_cache_hit": 2, "files_in_cache": 34})
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(24), mx["file_count_discrepancy"], "cache files counting must be rate-limited")
+
+	c.fileCountTime = time.Time{}
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["file_count_discrepancy"])
+	assert.False(t, c.fileCountWarned)
+}
+
+func TestCcache_Collect_PassthroughAllKeys(t *testing.T) {
+	c := New()
+	c.PassthroughAllKeys = true
+	c.exec = prepareMockVer48()
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+
+	stats, err := parseStatsText(dataVer48PrintStats)
+	require.NoError(t, err)
+	for key, v := range stats {
+		assert.Equalf(t, v, mx["raw_"+key], "key '%s'", key)
+	}
+
+	chart := c.Charts().Get(rawStatsChart.ID)
+	require.NotNil(t, chart)
+	assert.Len(t, chart.Dims, len(stats))
+	testMetricsHasAllChartsDims(t, c, mx)
+}
+
+func TestCcache_Collect_UnknownKeyPolicy(t *testing.T) {
+	tests := map[string]struct {
+		policy      unknownKeyPolicy
+		wantRaw     bool
+		wantWarned  bool
+		passthrough bool
+	}{
+		"ignore": {
+			policy: unknownKeyPolicyIgnore,
+		},
+		"warn": {
+			policy:     unknownKeyPolicyWarn,
+			wantWarned: true,
+		},
+		"passthrough": {
+			policy:  unknownKeyPolicyPassthrough,
+			wantRaw: true,
+		},
+		"passthrough with passthrough_all_keys": {
+			policy:      unknownKeyPolicyPassthrough,
+			wantRaw:     true,
+			passthrough: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+			c.UnknownKeyPolicy = string(test.policy)
+			c.PassthroughAllKeys = test.passthrough
+			m := prepareMockVer48()
+			m.printStatsData = append([]byte("fancy_new_counter\t7\n"), dataVer48PrintStats...)
+			c.exec = m
+			require.True(t, c.Init())
+
+			mx := c.Collect()
+			require.NotNil(t, mx)
+
+			if test.wantRaw {
+				assert.Equal(t, int64(7), mx["raw_fancy_new_counter"])
+				if !test.passthrough {
+					assert.NotContains(t, mx, "raw_direct_cache_hit", "known keys must not be passed through")
+				}
+				testMetricsHasAllChartsDims(t, c, mx)
+			} else {
+				assert.NotContains(t, mx, "raw_fancy_new_counter")
+				assert.Nil(t, c.Charts().Get(rawStatsChart.ID))
+			}
+			assert.Equal(t, test.wantWarned, c.warnedUnknownKeys["fancy_new_counter"])
+			assert.False(t, c.warnedUnknownKeys["direct_cache_hit"])
+		})
+	}
+}
+
+func TestCcache_Collect_DynamicDimsOrder(t *testing.T) {
+	dimIDs := func(chart *module.Chart) []string {
+		var ids []string
+		for _, dim := range chart.Dims {
+			ids = append(ids, dim.ID)
+		}
+		return ids
+	}
+
+	collectAll := func(t *testing.T, statsData ...string) *Ccache {
+		c := New()
+		c.PassthroughAllKeys = true
+		m := prepareMockVer48()
+		c.exec = m
+		require.True(t, c.Init())
+		for _, data := range statsData {
+			m.printStatsData = []byte(data)
+			require.NotNil(t, c.Collect())
+		}
+		return c
+	}
+
+	// the same stats in a different order, and a stat that appears only in a later collection
+	c1 := collectAll(t, "no_input_file\t1\ncalled_for_link\t2\nautoconf_test\t3\n")
+	c2 := collectAll(t,
+		"no_input_file\t1\n",
+		"autoconf_test\t3\nno_input_file\t1\ncalled_for_link\t2\n",
+	)
+
+	for _, id := range []string{uncacheableCallsChart.ID, recentMissReasonsChart.ID, rawStatsChart.ID} {
+		chart1, chart2 := c1.Charts().Get(id), c2.Charts().Get(id)
+		require.NotNilf(t, chart1, "chart '%s'", id)
+		require.NotNilf(t, chart2, "chart '%s'", id)
+		assert.Truef(t, sort.StringsAreSorted(dimIDs(chart1)), "chart '%s' dims are not sorted: %v", id, dimIDs(chart1))
+		assert.Equalf(t, dimIDs(chart1), dimIDs(chart2), "chart '%s' dims order", id)
+	}
+}
+
+func TestCcache_Collect_VersionChange(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+
+	require.NotNil(t, c.Collect())
+	chart := c.Charts().Get(hitsChart.ID)
+	require.NotNil(t, chart)
+	assert.Equal(t, []module.Label{{Key: "ccache_version", Value: "4.8.3"}}, chart.Labels)
+
+	m.versionData = dataVer410Version
+	m.helpData = dataVer410Help
+	m.printStatsJSONData = dataVer410PrintStatsJSON
+
+	require.NotNil(t, c.Collect())
+	assert.Equal(t, "4.8.3", c.version, "version check must be rate-limited")
+
+	c.versionCheckTime = time.Time{}
+	require.NotNil(t, c.Collect())
+	assert.Equal(t, "4.10.2", c.version)
+	assert.Equal(t, statsFormatJSON, c.statsFormat)
+	assert.Equal(t, []module.Label{{Key: "ccache_version", Value: "4.10.2"}}, chart.Labels)
+}
+
+func TestCcache_Collect_ExpectedVersion(t *testing.T) {
+	c := New()
+	c.ExpectedVersion = "4.8"
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+	require.NotNil(t, c.Charts().Get(versionMatchesExpectedChart.ID))
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1), mx["version_matches_expected"])
+
+	// another binary is found in PATH (e.g. a different environment module is loaded)
+	m.versionData = dataVer410Version
+	m.helpData = dataVer410Help
+	m.printStatsJSONData = dataVer410PrintStatsJSON
+	c.versionCheckTime = time.Time{}
+
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["version_matches_expected"])
+	assert.Equal(t, "4.10.2", c.mismatchedVersion, "the mismatch must be warned about")
+	chart := c.Charts().Get(hitsChart.ID)
+	require.NotNil(t, chart)
+	assert.Contains(t, chart.Labels, module.Label{Key: "ccache_version", Value: "4.10.2"})
+}
+
+func TestCcache_Init_ExpectedVersionMismatch(t *testing.T) {
+	c := New()
+	c.ExpectedVersion = "4.10.1"
+	c.exec = prepareMockVer410()
+	require.True(t, c.Init(), "a version mismatch must not fail the job")
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["version_matches_expected"])
+	assert.Equal(t, "4.10.2", c.mismatchedVersion)
+}
+
+func Test_versionMatches(t *testing.T) {
+	tests := map[string]struct {
+		version  string
+		expected string
+		want     bool
+	}{
+		"same version":         {version: "4.8.3", expected: "4.8.3", want: true},
+		"same series":          {version: "4.8.3", expected: "4.8", want: true},
+		"same major":           {version: "4.8.3", expected: "4", want: true},
+		"another patch":        {version: "4.8.3", expected: "4.8.2"},
+		"another series":       {version: "4.10.2", expected: "4.1"},
+		"another major":        {version: "3.4.0", expected: "4"},
+		"version not detected": {version: "", expected: "4.8"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.want, versionMatches(test.version, test.expected))
+		})
+	}
+}
+
+func TestCcache_Collect_CounterReset(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(4706), mx["direct_cache_hit"])
+	assert.Equal(t, int64(9836), mx["files_in_cache"])
+
+	// zeroed stats ('ccache -z')
+	m.printStatsData = []byte("direct_cache_hit\t2\ncache_miss\t1\nfiles_in_cache\t9000\n")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(4706+2), mx["direct_cache_hit"])
+	assert.Equal(t, int64(1300+1), mx["cache_miss"])
+	assert.Equal(t, int64(9000), mx["files_in_cache"], "gauges must not be adjusted")
+
+	m.printStatsData = []byte("direct_cache_hit\t5\ncache_miss\t1\nfiles_in_cache\t9000\n")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(4706+5), mx["direct_cache_hit"])
+}
+
+func TestCcache_Collect_CounterPartialDrop(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	m.printStatsData = []byte("direct_cache_hit\t1000\ncache_miss\t100\n")
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1000), mx["direct_cache_hit"])
+
+	// a partial reading (a failed node, a skipped stats file)
+	m.printStatsData = []byte("direct_cache_hit\t940\ncache_miss\t90\n")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1000), mx["direct_cache_hit"], "a partial drop must keep the previous value")
+	assert.Equal(t, int64(100), mx["cache_miss"])
+
+	m.printStatsData = []byte("direct_cache_hit\t1005\ncache_miss\t101\n")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1005), mx["direct_cache_hit"], "a partial drop must not be added to the offset")
+	assert.Equal(t, int64(101), mx["cache_miss"])
+}
+
+func TestCcache_Collect_CounterResetZeroedTimestamp(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	m.printStatsData = []byte("stats_zeroed_timestamp\t1699266061\ndirect_cache_hit\t1000\ncache_miss\t100\n")
+	c.exec = m
+	require.True(t, c.Init())
+	require.NotNil(t, c.Collect())
+
+	m.printStatsData = []byte("stats_zeroed_timestamp\t1699266061\ndirect_cache_hit\t10\ncache_miss\t1\n")
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1000), mx["direct_cache_hit"], "the stats were not zeroed, the drop is a partial reading")
+
+	// zeroed stats ('ccache -z'), the counters grew since
+	m.printStatsData = []byte("stats_zeroed_timestamp\t1699300000\ndirect_cache_hit\t600\ncache_miss\t60\n")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1000+600), mx["direct_cache_hit"])
+	assert.Equal(t, int64(100+60), mx["cache_miss"])
+}
+
+func TestCcache_Collect_CacheResets(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["cache_resets"])
+
+	// zeroed stats ('ccache -z'), several counters decrease at once
+	m.printStatsData = []byte("direct_cache_hit\t2\ncache_miss\t1\ncalled_for_link\t0\nfiles_in_cache\t9000\n")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1), mx["cache_resets"])
+
+	m.printStatsData = []byte("direct_cache_hit\t5\ncache_miss\t3\ncalled_for_link\t1\nfiles_in_cache\t8000\n")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1), mx["cache_resets"], "counters growing after a reset are not a reset")
+
+	// a partial reading (a failed node, a skipped stats file)
+	m.printStatsData = []byte("direct_cache_hit\t4\ncache_miss\t2\ncalled_for_link\t1\nfiles_in_cache\t8000\n")
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1), mx["cache_resets"], "a partial drop is not a reset")
+}
+
+func TestCcache_StateFile(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "ccache.state")
+
+	c := New()
+	c.StateFile = stateFile
+	m := prepareMockVer48()
+	m.printStatsData = []byte("direct_cache_hit\t10\ncache_miss\t10\ncalled_for_link\t0\ncache_size_kibibyte\t100\nfiles_in_cache\t10\n")
+	c.exec = m
+	require.True(t, c.Init())
+	require.NotNil(t, c.Collect())
+	require.FileExists(t, stateFile)
+
+	// an agent restart: the next job continues the recent window from the persisted counters
+	restarted := New()
+	restarted.StateFile = stateFile
+	m = prepareMockVer48()
+	m.printStatsData = []byte("direct_cache_hit\t20\ncache_miss\t10\ncalled_for_link\t0\ncache_size_kibibyte\t100\nfiles_in_cache\t10\n")
+	restarted.exec = m
+	require.True(t, restarted.Init())
+	assert.Len(t, restarted.sizeSamples, 1)
+
+	mx := restarted.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(100*precision), mx["recent_cache_hit_percentage"])
+}
+
+func TestCcache_StateFile_StartsFresh(t *testing.T) {
+	tests := map[string]func(t *testing.T, path string){
+		"missing": func(t *testing.T, path string) {},
+		"corrupt": func(t *testing.T, path string) {
+			require.NoError(t, os.WriteFile(path, []byte(`{"version": 1, "counters": {`), 0644))
+		},
+		"unsupported version": func(t *testing.T, path string) {
+			require.NoError(t, os.WriteFile(path, []byte(`{"version": 100, "counters": {"direct_cache_hit": 1}}`), 0644))
+		},
+		"too big": func(t *testing.T, path string) {
+			require.NoError(t, os.WriteFile(path, bytes.Repeat([]byte(" "), stateFileMaxSize+1), 0644))
+		},
+		"outdated": func(t *testing.T, path string) {
+			st := fmt.Sprintf(`{"version": 1, "timestamp": %d, "counters": {"direct_cache_hit": 1},
+"size_samples": [{"timestamp": %[1]d, "size": 100}]}`, time.Now().Add(-2*stateMaxAge).Unix())
+			require.NoError(t, os.WriteFile(path, []byte(st), 0644))
+		},
+	}
+
+	for name, prepare := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+			c.StateFile = filepath.Join(t.TempDir(), "ccache.state")
+			c.exec = prepareMockVer48()
+			prepare(t, c.StateFile)
+
+			require.True(t, c.Init())
+			assert.Nil(t, c.prevStats)
+			assert.Empty(t, c.sizeSamples)
+
+			require.NotNil(t, c.Collect())
+			assert.FileExists(t, c.StateFile)
+		})
+	}
+}
+
+func TestCcache_Collect_Nodes(t *testing.T) {
+	srvText := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(dataVer48PrintStats)
+	}))
+	defer srvText.Close()
+	srvJSON := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(dataVer410PrintStatsJSON)
+	}))
+	defer srvJSON.Close()
+	srvGzip := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/gzip")
+		_, _ = w.Write(dataVer410PrintStatsJSONGzip)
+	}))
+	defer srvGzip.Close()
+	srvFail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srvFail.Close()
+
+	c := New()
+	c.CollectionMode = string(collectionModeNodes)
+	c.NodeBreakdown = true
+	servers := map[string]*httptest.Server{"text": srvText, "json": srvJSON, "gzip": srvGzip, "fail": srvFail}
+	for name, srv := range servers {
+		c.Nodes = append(c.Nodes, NodeConfig{Name: name, HTTP: web.HTTP{Request: web.Request{URL: srv.URL}}})
+	}
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+
+	assert.Equal(t, int64(4706*3), mx["direct_cache_hit"])
+	assert.Equal(t, int64(1300*3), mx["cache_miss"])
+	assert.Equal(t, int64(4706+185), mx["node_text_cache_hit"])
+	assert.Equal(t, int64(1300), mx["node_json_cache_miss"])
+	assert.Equal(t, int64(1300), mx["node_gzip_cache_miss"])
+	assert.NotContains(t, mx, "node_fail_cache_hit")
+
+	chart := c.Charts().Get("node_text_calls")
+	require.NotNil(t, chart)
+	assert.Equal(t, []module.Label{{Key: "node", Value: "text"}}, chart.Labels)
+}
+
+func TestCcache_Collect_NodesDottedHost(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(dataVer48PrintStats)
+	}))
+	defer srv.Close()
+
+	c := New()
+	c.CollectionMode = string(collectionModeNodes)
+	c.NodeBreakdown = true
+	c.Nodes = []NodeConfig{{HTTP: web.HTTP{Request: web.Request{URL: srv.URL}}}}
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	id := strings.NewReplacer(".", "_", ":", "_").Replace(host)
+	assert.Equal(t, int64(4706+185), mx["node_"+id+"_cache_hit"])
+
+	chart := c.Charts().Get("node_" + id + "_calls")
+	require.NotNil(t, chart, "the host dots and colon must be sanitized in the chart ID")
+	assert.Equal(t, []module.Label{{Key: "node", Value: host}}, chart.Labels, "the label keeps the host")
+	for _, dim := range chart.Dims {
+		assert.NotContains(t, dim.ID, ".")
+		assert.NotContains(t, dim.ID, ":")
+	}
+
+	c = New()
+	c.CollectionMode = string(collectionModeNodes)
+	c.Nodes = []NodeConfig{
+		{Name: "build.a", HTTP: web.HTTP{Request: web.Request{URL: srv.URL}}},
+		{Name: "build_a", HTTP: web.HTTP{Request: web.Request{URL: srv.URL}}},
+	}
+	assert.False(t, c.Init(), "the names must be unique once sanitized")
+}
+
+func TestCcache_Collect_CacheDirs(t *testing.T) {
+	root := t.TempDir()
+	writeStatsFile(t, filepath.Join(root, "alice", "0", "stats"), map[string]int64{"direct_cache_hit": 10, "cache_miss": 1})
+	writeStatsFile(t, filepath.Join(root, "bob.smith", "1", "stats"), map[string]int64{"direct_cache_hit": 20, "cache_miss": 2})
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "not-a-cache", "tmp"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "README"), []byte("caches"), 0644))
+
+	c := New()
+	c.CollectionMode = string(collectionModeDirs)
+	c.CacheDirsRoot = root
+	c.BinaryPath = ""
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+
+	bob := "cache_dir_" + cacheDirName(filepath.Join(root, "bob.smith"))
+	assert.Equal(t, int64(30), mx["direct_cache_hit"])
+	assert.Equal(t, int64(3), mx["cache_miss"])
+	assert.Equal(t, int64(10), mx["cache_dir_alice_cache_hit"])
+	assert.Equal(t, int64(2), mx[bob+"_cache_miss"])
+	assert.NotContains(t, mx, "cache_dir_not-a-cache_cache_hit")
+
+	chart := c.Charts().Get(bob + "_calls")
+	require.NotNil(t, chart)
+	assert.Equal(t, []module.Label{{Key: "cache_dir", Value: filepath.Join(root, "bob.smith")}}, chart.Labels)
+
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "bob.smith")))
+	for i := 1; i < maxCacheDirNotSeenTimes; i++ {
+		mx = c.Collect()
+		require.NotNil(t, mx)
+		assert.Equal(t, int64(30), mx["direct_cache_hit"], "a missing cache must not lower the totals")
+		assert.Equal(t, int64(20), mx[bob+"_cache_hit"])
+		assert.False(t, chart.Obsolete, "a missing cache chart must be kept for %d collections", maxCacheDirNotSeenTimes)
+	}
+
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.NotContains(t, mx, bob+"_cache_hit")
+	assert.True(t, chart.Obsolete, "vanished cache chart must be removed")
+
+	c = New()
+	c.CollectionMode = string(collectionModeDirs)
+	assert.False(t, c.Init(), "must fail without 'cache_dirs_root'")
+}
+
+func TestCcache_Collect_CacheDirsNameCollision(t *testing.T) {
+	root := t.TempDir()
+	writeStatsFile(t, filepath.Join(root, "team.a", "0", "stats"), map[string]int64{"direct_cache_hit": 10, "cache_miss": 1})
+	writeStatsFile(t, filepath.Join(root, "team_a", "0", "stats"), map[string]int64{"direct_cache_hit": 20, "cache_miss": 2})
+
+	c := New()
+	c.CollectionMode = string(collectionModeDirs)
+	c.CacheDirsRoot = root
+	c.BinaryPath = ""
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+
+	dotted := cacheDirName(filepath.Join(root, "team.a"))
+	assert.NotEqual(t, "team_a", dotted, "sibling caches must not share the charts")
+	assert.Equal(t, int64(10), mx["cache_dir_"+dotted+"_cache_hit"])
+	assert.Equal(t, int64(20), mx["cache_dir_team_a_cache_hit"])
+	assert.True(t, c.Charts().Has("cache_dir_"+dotted+"_calls"))
+	assert.True(t, c.Charts().Has("cache_dir_team_a_calls"))
+}
+
+func Test_cacheDirName(t *testing.T) {
+	assert.Equal(t, "alice", cacheDirName("/caches/alice"))
+	assert.Equal(t, cacheDirName("/caches/team.a"), cacheDirName("/other/team.a"), "the name must be stable")
+	assert.Regexp(t, `^team_a_[0-9a-f]{8}$`, cacheDirName("/caches/team.a"))
+	assert.NotEqual(t, cacheDirName("/caches/team.a"), cacheDirName("/caches/team:a"))
+}
+
+func TestCcache_Collect_URL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write(dataVer410PrintStatsJSON)
+	}))
+	defer srv.Close()
+
+	c := New()
+	c.URL = srv.URL
+	c.Username, c.Password = "user", "pass"
+	c.BinaryPath = ""
+	require.True(t, c.Init())
+	assert.Equal(t, string(collectionModeURL), c.CollectionMode)
+	assert.Nil(t, c.exec, "ccache must not be executed")
+
+	assert.Equal(t, expectedVer4Metrics, c.Collect())
+
+	c.Password = "wrong"
+	c.urlNode, _ = c.initURLNode()
+	_, err := c.collect()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401 status code")
+
+	srv.Close()
+	_, err = c.collect()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error on connecting to")
+}
+
+func TestCcache_Cleanup_DrainsRemoteRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second * 5):
+		}
+	}))
+	defer srv.Close()
+
+	before := runtime.NumGoroutine()
+
+	c := New()
+	c.URL = srv.URL
+	c.Timeout = web.Duration{Duration: time.Second * 5}
+	require.True(t, c.Init())
+
+	done := make(chan struct{})
+	go func() { defer close(done); c.Collect() }()
+	time.Sleep(time.Millisecond * 100)
+
+	start := time.Now()
+	c.Cleanup()
+
+	select {
+	case <-done:
+	case <-time.After(cleanupDrainTimeout):
+		t.Fatal("Cleanup() did not cancel the in-flight request")
+	}
+	assert.Less(t, time.Since(start), cleanupDrainTimeout)
+
+	// not assert.Eventually(), it runs the condition in its own goroutines
+	after := runtime.NumGoroutine()
+	for deadline := time.Now().Add(time.Second * 2); after > before && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond * 50)
+		after = runtime.NumGoroutine()
+	}
+	assert.LessOrEqualf(t, after, before, "goroutines leaked: before %d, after %d", before, after)
+}
+
+func TestCcache_Cleanup_CancelsStatsFilesScan(t *testing.T) {
+	dir := t.TempDir()
+	writeStatsFile(t, filepath.Join(dir, "0", "stats"), map[string]int64{"direct_cache_hit": 1})
+
+	release := make(chan struct{})
+	defer close(release)
+
+	c := New()
+	c.CollectionMode = string(collectionModeFile)
+	c.CacheDir = dir
+	c.Timeout = web.Duration{Duration: time.Minute}
+	c.readFile = func(name string) ([]byte, error) {
+		<-release // hung (e.g. NFS mounted) cache dir
+		return os.ReadFile(name)
+	}
+	require.True(t, c.Init())
+
+	done := make(chan map[string]int64)
+	go func() { done <- c.Collect() }()
+	time.Sleep(time.Millisecond * 100)
+
+	c.Cleanup()
+
+	select {
+	case mx := <-done:
+		assert.Nil(t, mx)
+	case <-time.After(time.Second):
+		t.Fatal("Cleanup() did not abort the in-progress stats files scan")
+	}
+}
+
+func TestCcache_Collect_CollectErrors(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["collect_errors"])
+
+	m.errOnStats = true
+	assert.Nil(t, c.Collect())
+	m.printStatsData = []byte("garbage")
+	m.errOnStats = false
+	assert.Nil(t, c.Collect())
+
+	m.printStatsData = dataVer48PrintStats
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(2), mx["collect_errors"])
+}
+
+func TestCcache_Collect_CollectStreaks(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+
+	for i := int64(1); i <= 3; i++ {
+		mx := c.Collect()
+		require.NotNil(t, mx)
+		assert.Equal(t, i, mx["consecutive_successful_collects"])
+		assert.Equal(t, int64(0), mx["consecutive_failed_collects"])
+	}
+
+	m.errOnStats = true
+	assert.Nil(t, c.Collect())
+	assert.Nil(t, c.Collect())
+	m.errOnStats = false
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1), mx["consecutive_successful_collects"], "a failure resets the successes streak")
+	assert.Equal(t, int64(2), mx["consecutive_failed_collects"], "the ended failures streak is reported")
+
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(2), mx["consecutive_successful_collects"])
+	assert.Equal(t, int64(0), mx["consecutive_failed_collects"])
+}
+
+func TestCcache_Collect_LastCleanup(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.NotContains(t, mx, "seconds_since_last_cleanup")
+	assert.Nil(t, c.Charts().Get(lastCleanupChart.ID))
+
+	m.printStatsData = []byte(strings.Replace(string(dataVer48PrintStats),
+		"cleanups_performed\t4", "cleanups_performed\t5", 1))
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(0), mx["seconds_since_last_cleanup"])
+	assert.NotNil(t, c.Charts().Get(lastCleanupChart.ID))
+
+	c.lastCleanupTime = c.lastCleanupTime.Add(-time.Minute)
+	mx = c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(60), mx["seconds_since_last_cleanup"])
+}
+
+func TestCcache_Collect_BuildID(t *testing.T) {
+	tests := map[string]struct {
+		buildID    string
+		buildIDEnv string
+		env        map[string]string
+		wantLabel  string
+	}{
+		"not set": {},
+		"from config": {
+			buildID:    "pipeline-42",
+			buildIDEnv: "TEST_CCACHE_BUILD_ID",
+			env:        map[string]string{"TEST_CCACHE_BUILD_ID": "job-1"},
+			wantLabel:  "pipeline-42",
+		},
+		"from env": {
+			buildIDEnv: "TEST_CCACHE_BUILD_ID",
+			env:        map[string]string{"TEST_CCACHE_BUILD_ID": "job-1"},
+			wantLabel:  "job-1",
+		},
+		"env not set": {
+			buildIDEnv: "TEST_CCACHE_BUILD_ID_UNSET",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+			c := New()
+			c.BuildID = test.buildID
+			c.BuildIDEnv = test.buildIDEnv
+			c.exec = prepareMockVer48()
+			require.True(t, c.Init())
+			require.NotNil(t, c.Collect())
+
+			for _, chart := range *c.Charts() {
+				var got string
+				for _, lbl := range chart.Labels {
+					if lbl.Key == "build_id" {
+						got = lbl.Value
+					}
+				}
+				assert.Equalf(t, test.wantLabel, got, "chart '%s'", chart.ID)
+			}
+		})
+	}
+}
+
+func TestCcache_Collect_DistributedCompilerLabel(t *testing.T) {
+	tests := map[string]struct {
+		env    map[string]string
+		jobEnv map[string]string
+		config string
+		// configPath is the 'config_path' option: the config file is written there instead of the cache directory.
+		configPath bool
+		wantLabel  string
+	}{
+		"not set": {},
+		"job 'environment' CCACHE_PREFIX": {
+			env:       map[string]string{"CCACHE_PREFIX": "distcc"},
+			jobEnv:    map[string]string{"CCACHE_PREFIX": "icecc"},
+			wantLabel: "icecc",
+		},
+		"job 'config_path'": {
+			config:     "prefix_command = icecc\n",
+			configPath: true,
+			wantLabel:  "icecc",
+		},
+		"CCACHE_PREFIX": {
+			env:       map[string]string{"CCACHE_PREFIX": "distcc"},
+			wantLabel: "distcc",
+		},
+		"CCACHE_PREFIX overrides the config file": {
+			env:       map[string]string{"CCACHE_PREFIX": "/usr/bin/icecc"},
+			config:    "prefix_command = distcc\n",
+			wantLabel: "icecc",
+		},
+		"config file prefix_command": {
+			config:    "max_size = 5G\n# prefix_command = distcc\nprefix_command = pump distcc\n",
+			wantLabel: "distcc",
+		},
+		"unrelated prefix_command": {
+			config: "prefix_command = time\n",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("CCACHE_PREFIX", "")
+			t.Setenv("CCACHE_CONFIGPATH", "")
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+			configPath := filepath.Join(dir, "ccache.conf")
+			if test.configPath {
+				configPath = filepath.Join(t.TempDir(), "builds.conf")
+			}
+			if test.config != "" {
+				require.NoError(t, os.WriteFile(configPath, []byte(test.config), 0644))
+			}
+
+			c := New()
+			c.CacheDir = dir
+			c.Environment = test.jobEnv
+			if test.configPath {
+				c.ConfigPath = configPath
+			}
+			c.exec = prepareMockVer48()
+			require.True(t, c.Init())
+			require.NotNil(t, c.Collect())
+
+			for _, chart := range *c.Charts() {
+				var got string
+				for _, lbl := range chart.Labels {
+					if lbl.Key == "distributed_compiler" {
+						got = lbl.Value
+					}
+				}
+				assert.Equalf(t, test.wantLabel, got, "chart '%s'", chart.ID)
+			}
+		})
+	}
+}
+
+func TestCcache_Collect_SelfTest(t *testing.T) {
+	stats, err := parseStatsText(dataVer48PrintStats)
+	require.NoError(t, err)
+	stats["cache_miss"] -= 100
+
+	dir := t.TempDir()
+	writeStatsFile(t, filepath.Join(dir, "0", "stats"), stats)
+
+	c := New()
+	c.SelfTest = true
+	c.CacheDir = dir
+	c.exec = prepareMockVer48()
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1300), mx["cache_miss"], "exec stats must be reported")
+
+	execStats, err := c.queryStats()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cache_miss"}, c.selfTest(execStats))
+}
+
+func TestCcache_Collect_CacheSizeKey(t *testing.T) {
+	tests := map[string]struct {
+		stats string
+		want  int64
+	}{
+		"kibibytes ('cache_size_kibibyte')": {stats: "cache_size_kibibyte\t2\n", want: 2048},
+		"bytes ('cache_size_bytes')":        {stats: "cache_size_bytes\t2048\n", want: 2048},
+		"bytes ('cache_size')":              {stats: "cache_size\t2048\n", want: 2048},
+		"no size key":                       {stats: "files_in_cache\t1\n", want: 0},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+			m := prepareMockVer48()
+			m.printStatsData = []byte("direct_cache_hit\t1\n" + test.stats)
+			c.exec = m
+			require.True(t, c.Init())
+
+			for i := 0; i < 2; i++ {
+				mx := c.Collect()
+				require.NotNil(t, mx)
+				assert.Equal(t, test.want, mx["cache_size"])
+			}
+		})
+	}
+}
+
+func TestCcache_Collect_CacheSize(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	m.printStatsData = []byte(strings.Replace(string(dataVer48PrintStats),
+		"cache_size_kibibyte\t2827652", "cache_size_kibibyte\t209715200", 1)) // 200 GiB
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+
+	assert.Equal(t, int64(200*1024*1024*1024), mx["cache_size"])
+
+	chart := c.Charts().Get(cacheSizeChart.ID)
+	require.NotNil(t, chart)
+	assert.Equal(t, "bytes", chart.Units)
+	for _, dim := range chart.Dims {
+		assert.LessOrEqualf(t, dim.Mul, 1, "dim '%s' must not be scaled", dim.ID)
+		assert.LessOrEqualf(t, dim.Div, 1, "dim '%s' must not be scaled", dim.ID)
+	}
+}
+
+func TestCcache_Collect_SkipIfIdle(t *testing.T) {
+	dir := t.TempDir()
+	statsFile := filepath.Join(dir, "0", "stats")
+	require.NoError(t, os.MkdirAll(filepath.Dir(statsFile), 0755))
+	require.NoError(t, os.WriteFile(statsFile, nil, 0644))
+	mtime := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(statsFile, mtime, mtime))
+
+	c := New()
+	c.CacheDir = dir
+	c.SkipIfIdle = true
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx1 := c.Collect()
+	require.NotNil(t, mx1)
+	assert.Equal(t, 1, m.statsCalls)
+
+	c.statsTime = c.statsTime.Add(-time.Minute)
+	mx2 := c.Collect()
+	assert.GreaterOrEqual(t, mx2["metrics_age_seconds"], int64(60), "reused values age must be reported")
+	assert.Equal(t, int64(2), mx2["consecutive_successful_collects"])
+	for _, key := range []string{"metrics_age_seconds", "consecutive_successful_collects"} {
+		delete(mx1, key)
+		delete(mx2, key)
+	}
+	assert.Equal(t, mx1, mx2, "idle cache must reuse previous values")
+	assert.Equal(t, 1, m.statsCalls, "idle cache must not be queried")
+	assert.True(t, c.Check())
+
+	mtime = mtime.Add(time.Minute)
+	require.NoError(t, os.Chtimes(statsFile, mtime, mtime))
+
+	mx3 := c.Collect()
+	require.NotNil(t, mx3)
+	assert.Equal(t, 2, m.statsCalls, "used cache must be queried")
+	assert.Equal(t, int64(0), mx3["metrics_age_seconds"])
+}
+
+func TestCcache_Collect_DebugRawOutput(t *testing.T) {
+	c := New()
+	c.DebugRawOutput = true
+	c.exec = prepareMockVer48()
+	require.True(t, c.Init())
+
+	require.NotNil(t, c.Collect())
+	logged := c.rawOutputLogTime
+	assert.False(t, logged.IsZero())
+
+	require.NotNil(t, c.Collect())
+	assert.Equal(t, logged, c.rawOutputLogTime, "raw output logging must be throttled")
+}
+
+func TestCcache_HandleFunction(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	m.printStatsData = append([]byte("Primary config: /home/jdoe/.config/ccache/ccache.conf\n"), dataVer48PrintStats...)
+	c.exec = m
+	require.True(t, c.Init())
+
+	calls := m.statsCalls
+	bs, err := c.HandleFunction(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, m.statsCalls-calls, "the function must run a single command")
+
+	var res functionResult
+	require.NoError(t, json.Unmarshal(bs, &res))
+
+	assert.Equal(t, "4.8.3", res.Version)
+	assert.Equal(t, string(statsFormatText), res.StatsFormat)
+	assert.Equal(t, "ccache --print-stats", res.Command)
+	assert.NotContains(t, res.Raw, "jdoe")
+	assert.Equal(t, int64(1300), res.Stats["cache_miss"])
+
+	m.errOnStats = true
+	_, err = c.HandleFunction(context.Background(), nil)
+	assert.Error(t, err)
+
+	c = New()
+	c.CollectionMode = string(collectionModeFile)
+	_, err = c.HandleFunction(context.Background(), nil)
+	assert.Error(t, err, "function must be rejected outside exec mode")
+}
+
+func TestCcache_HandleFunction_OpenMetrics(t *testing.T) {
+	c := New()
+	c.exec = prepareMockVer48()
+	require.True(t, c.Init())
+
+	_, err := c.HandleFunction(context.Background(), []string{openMetricsFunction})
+	assert.Error(t, err, "nothing to render before the first collection")
+	_, err = c.HandleFunction(context.Background(), []string{"unknown"})
+	assert.Error(t, err)
+
+	require.NotNil(t, c.Collect())
+
+	bs, err := c.HandleFunction(context.Background(), []string{openMetricsFunction})
+	require.NoError(t, err)
+	var res openMetricsResult
+	require.NoError(t, json.Unmarshal(bs, &res))
+	assert.Equal(t, openMetricsContentType, res.ContentType)
+
+	assert.Contains(t, res.Metrics, "# HELP ccache_cache_miss Cache misses (miss)\n# TYPE ccache_cache_miss counter\n")
+	assert.Regexp(t, `(?m)^ccache_cache_miss_total 1300 \d+(\.\d+)?$`, res.Metrics)
+	assert.Contains(t, res.Metrics, "# TYPE ccache_cache_hit_percentage gauge\n")
+	assert.Regexp(t, `(?m)^ccache_cache_hit_percentage 79\.001 `, res.Metrics)
+	assert.True(t, strings.HasSuffix(res.Metrics, "\n# EOF\n"))
+
+	// every metric family has its TYPE, followed by its sample
+	lines := strings.Split(strings.TrimSuffix(res.Metrics, "\n"), "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		parts := strings.Fields(line)
+		require.Len(t, parts, 4, line)
+		require.Less(t, i+1, len(lines))
+		sample := parts[2]
+		if parts[3] == "counter" {
+			sample += "_total"
+		}
+		assert.Truef(t, strings.HasPrefix(lines[i+1], sample+" "), "'%s' is not followed by its sample", line)
+	}
+}
+
+func Test_openMetricsName(t *testing.T) {
+	assert.Equal(t, "ccache_cache_miss", openMetricsName("cache_miss"))
+	assert.Equal(t, "ccache_node_build_1_cache_miss", openMetricsName("node_build-1_cache_miss"))
+	assert.Equal(t, "ccache__tmp_ccache_files_in_cache", openMetricsName("/tmp/ccache_files_in_cache"))
+}
+
+func TestCcache_Collect_Sccache(t *testing.T) {
+	c := New()
+	c.Tool = string(toolSccache)
+	c.UnknownKeyPolicy = string(unknownKeyPolicyPassthrough)
+	m := prepareMockSccache07()
+	c.exec = m
+	require.True(t, c.Init())
+	assert.Equal(t, statsFormatSccache, c.statsFormat)
+	assert.Equal(t, "0.7.4", c.version)
+	assert.Equal(t, "sccache", c.BinaryPath, "default binary must follow the tool")
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	testMetricsHasAllChartsDims(t, c, mx)
+
+	assert.Equal(t, int64(850), mx["preprocessed_cache_hit"])
+	assert.Equal(t, int64(0), mx["direct_cache_hit"])
+	assert.Equal(t, int64(162), mx["cache_miss"])
+	assert.Equal(t, int64(1073741824), mx["cache_size"])
+	assert.Equal(t, int64(7), mx["compile_failed"])
+	assert.Equal(t, int64(3), mx["recache"])
+	assert.Equal(t, int64(12), mx["raw_sccache_requests_not_cacheable"], "tool specific counters are unknown keys")
+	assert.NotContains(t, mx, "raw_sccache_cache_write_duration", "durations must not be collected")
+
+	bs, err := c.HandleFunction(context.Background(), nil)
+	require.NoError(t, err)
+	var res functionResult
+	require.NoError(t, json.Unmarshal(bs, &res))
+	assert.Equal(t, "sccache --show-stats --stats-format=json", res.Command)
+	assert.Equal(t, int64(850), res.Stats["preprocessed_cache_hit"])
+}
+
+func Test_parseStatsSccache(t *testing.T) {
+	stats, err := parseStatsSccache(dataSccache07StatsJSON)
+	require.NoError(t, err)
+	assert.Equal(t, int64(850), stats["preprocessed_cache_hit"])
+	assert.Equal(t, int64(1073741824), stats["cache_size_bytes"])
+	assert.Equal(t, int64(0), stats["sccache_cache_errors"], "per language counters are summed")
+	assert.Equal(t, int64(1215), stats["sccache_compile_requests"])
+
+	_, err = parseStatsSccache([]byte(`{"cache_size": 1}`))
+	assert.Error(t, err)
+	_, err = parseStatsSccache(dataVer48PrintStats)
+	assert.Error(t, err)
+}
+
+func Test_redactHomeDirs(t *testing.T) {
+	in := "cache directory /home/jdoe/.cache/ccache\nprimary config /Users/jdoe/Library/ccache.conf\n"
+	want := "cache directory /home/***/.cache/ccache\nprimary config /Users/***/Library/ccache.conf\n"
+
+	assert.Equal(t, want, string(redactHomeDirs([]byte(in))))
+}
+
+func Test_ccacheExec_executeError(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	e := newCcacheExec(context.Background(), sh, New().Config, nil)
+
+	_, err = e.execute("-c", "echo 'permission denied' >&2; exit 3")
+	require.Error(t, err)
+
+	var ee *execError
+	require.True(t, errors.As(fmt.Errorf("exec ccache stats: %w", err), &ee))
+	assert.Equal(t, 3, ee.exitCode)
+	assert.Equal(t, "permission denied", ee.stderr)
+	assert.Contains(t, ee.cmd, sh)
+}
+
+func Test_classifyCollectError(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	dir := t.TempDir()
+	notExecutable := filepath.Join(dir, "ccache")
+	require.NoError(t, os.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0644))
+
+	execErr := func(binPath string, timeout time.Duration, arg ...string) error {
+		cfg := New().Config
+		if timeout > 0 {
+			cfg.Timeout = web.Duration{Duration: timeout}
+		}
+		_, err := newCcacheExec(context.Background(), binPath, cfg, nil).execute(arg...)
+		require.Error(t, err)
+		return fmt.Errorf("exec ccache stats ('text' format): %w", err)
+	}
+	collectErr := func(c *Ccache) error {
+		require.True(t, c.Init())
+		_, err := c.collect()
+		require.Error(t, err)
+		return err
+	}
+
+	tests := map[string]struct {
+		err  func() error
+		want checkErrorClass
+	}{
+		"binary removed": {
+			err:  func() error { return execErr(filepath.Join(dir, "missing"), 0, "--print-stats") },
+			want: checkErrorBinaryMissing,
+		},
+		"binary not in PATH": {
+			err: func() error {
+				_, err := exec.LookPath("ccache-does-not-exist")
+				return err
+			},
+			want: checkErrorBinaryMissing,
+		},
+		"binary not executable": {
+			err:  func() error { return execErr(notExecutable, 0, "--print-stats") },
+			want: checkErrorPermissionDenied,
+		},
+		"cache dir not accessible": {
+			err: func() error {
+				return execErr(sh, 0, "-c", "echo 'ccache: error: Failed to create directory /cache/0: Permission denied' >&2; exit 1")
+			},
+			want: checkErrorPermissionDenied,
+		},
+		"timeout": {
+			err:  func() error { return execErr(sh, 50*time.Millisecond, "-c", "exec sleep 5") },
+			want: checkErrorTimeout,
+		},
+		"unparseable output": {
+			err: func() error {
+				c := New()
+				m := prepareMockVer48()
+				m.printStatsData = []byte("<html>502 Bad Gateway</html>\n")
+				c.exec = m
+				return collectErr(c)
+			},
+			want: checkErrorUnparseableOutput,
+		},
+		"malformed json": {
+			err: func() error {
+				c := New()
+				m := prepareMockVer410()
+				m.printStatsJSONData = []byte(`{"direct_cache_hit": `)
+				c.exec = m
+				return collectErr(c)
+			},
+			want: checkErrorUnparseableOutput,
+		},
+		"no activity": {
+			err: func() error {
+				c := New()
+				c.CollectionMode = string(collectionModeFile)
+				c.CacheDir = t.TempDir()
+				return collectErr(c)
+			},
+			want: checkErrorNoActivity,
+		},
+		"other": {
+			err:  func() error { return execErr(sh, 0, "-c", "exit 2") },
+			want: checkErrorUnknown,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.err()
+			assert.Equalf(t, test.want, classifyCollectError(err), "error: %v", err)
+		})
+	}
+}
+
+func TestCcache_Check_LogsErrorClass(t *testing.T) {
+	c := New()
+	m := prepareMockVer48()
+	c.exec = m
+	require.True(t, c.Init())
+	m.printStatsData = []byte("garbage\n")
+
+	assert.False(t, c.Check())
+	assert.Equal(t, checkErrorUnparseableOutput, classifyCollectError(c.lastCollectErr))
+
+	m.printStatsData = dataVer48PrintStats
+	assert.True(t, c.Check())
+	assert.NoError(t, c.lastCollectErr)
+}
+
+func Test_ccacheExec_commandWrapper(t *testing.T) {
+	env, err := exec.LookPath("env")
+	if err != nil {
+		t.Skip("env not found")
+	}
+	cfg := New().Config
+	cfg.CommandWrapper = []string{env, "CCACHE_WRAPPED=1"}
+
+	e := newCcacheExec(context.Background(), "sh", cfg, nil)
+	bs, err := e.execute("-c", "echo $CCACHE_WRAPPED")
+	require.NoError(t, err)
+	assert.Equal(t, "1\n", string(bs))
+
+	_, err = e.execute("-c", "exit 125")
+	var ee *execError
+	require.ErrorAs(t, err, &ee)
+	assert.True(t, ee.wrapped)
+	assert.Contains(t, ee.cmd, env)
+	assert.Equal(t, []string{env, "CCACHE_WRAPPED=1"}, cfg.CommandWrapper, "wrapper must not be modified")
+}
+
+func Test_ccacheExec_sameEnvironment(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	bin := filepath.Join(t.TempDir(), "ccache")
+	script := "#!/bin/sh\necho \"$CCACHE_DIR $CCACHE_CONFIGPATH $CCACHE_MAXSIZE $CCACHE_SLOPPINESS\"\n"
+	require.NoError(t, os.WriteFile(bin, []byte(script), 0755))
+
+	cfg := New().Config
+	cfg.CacheDir = "/var/cache/ccache"
+	cfg.ConfigPath = "/etc/ccache/builds.conf"
+	cfg.Environment = map[string]string{
+		"CCACHE_MAXSIZE":    "20G",
+		"CCACHE_SLOPPINESS": "time_macros",
+		// the options take precedence
+		"CCACHE_DIR": "/tmp/ccache",
+	}
+	e := newCcacheExec(context.Background(), bin, cfg, nil)
+
+	want := "/var/cache/ccache /etc/ccache/builds.conf 20G time_macros\n"
+	for name, run := range map[string]func() ([]byte, error){
+		"--version":     e.version,
+		"--help":        e.help,
+		"--print-stats": e.printStats,
+		"--show-stats":  func() ([]byte, error) { return e.showStats("--show-stats") },
+	} {
+		bs, err := run()
+		require.NoErrorf(t, err, "'%s'", name)
+		assert.Equalf(t, want, string(bs), "'%s' environment", name)
+	}
+}
+
+func TestCcache_Collect_ForcedCLocale(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	testdata, err := filepath.Abs("testdata")
+	require.NoError(t, err)
+
+	// a ccache printing the localized numbers (comma decimal, dot grouping) unless in the C locale
+	bin := filepath.Join(t.TempDir(), "ccache")
+	script := fmt.Sprintf(`#!/bin/sh
+[ "$CCACHE_SLOPPINESS" = time_macros ] || exit 3
+case "$1" in
+  --version) cat '%[1]s/version-4.8.txt' ;;
+  --help) cat '%[1]s/help-4.8.txt' ;;
+  --print-stats)
+    if [ "$LC_ALL" = C ] && [ "$LANG" = C ]; then
+      printf 'direct_cache_hit\t1500\ncache_miss\t250\n'
+    else
+      printf 'direct_cache_hit\t1.500\ncache_miss\t250\n'
+    fi ;;
+  *) exit 1 ;;
+esac
+`, testdata)
+	require.NoError(t, os.WriteFile(bin, []byte(script), 0755))
+
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+	t.Setenv("LANG", "de_DE.UTF-8")
+
+	c := New()
+	c.BinaryPath = bin
+	c.Environment = map[string]string{"LC_NUMERIC": "de_DE.UTF-8", "LANG": "de_DE.UTF-8", "CCACHE_SLOPPINESS": "time_macros"}
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(1500), mx["direct_cache_hit"])
+	assert.Equal(t, int64(250), mx["cache_miss"])
+}
+
+func Test_ccacheExec_doesNotBlockOnStdin(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	bin := filepath.Join(t.TempDir(), "ccache")
+	script := "#!/bin/sh\nread -r answer\necho \"answer: $answer\"\n"
+	require.NoError(t, os.WriteFile(bin, []byte(script), 0755))
+
+	cfg := New().Config
+	cfg.Timeout = web.Duration{Duration: time.Second * 5}
+	e := newCcacheExec(context.Background(), bin, cfg, nil)
+
+	start := time.Now()
+	bs, err := e.printStats()
+	require.NoError(t, err)
+	assert.Equal(t, "answer: \n", string(bs), "stdin must be empty")
+	assert.Less(t, time.Since(start), cfg.Timeout.Duration, "must not wait for the timeout")
+}
+
+func TestCcache_HandleFunction_Cancel(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	bin := filepath.Join(t.TempDir(), "ccache")
+	script := "#!/bin/sh\n" +
+		"case \"$1\" in\n" +
+		"--version) echo 'ccache version 4.8.3' ;;\n" +
+		"--help) echo '--print-stats' ;;\n" +
+		"*) exec sleep 10 ;;\n" +
+		"esac\n"
+	require.NoError(t, os.WriteFile(bin, []byte(script), 0755))
+
+	c := New()
+	c.BinaryPath = bin
+	c.Timeout = web.Duration{Duration: time.Second * 10}
+	require.True(t, c.Init())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.HandleFunction(ctx, nil)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second*5, "the cancelled request must kill the process")
+}
+
+func TestCcache_SafeMode_NoProcessSpawned(t *testing.T) {
+	dir := t.TempDir()
+	writeStatsFile(t, filepath.Join(dir, "0", "stats"), map[string]int64{"direct_cache_hit": 1})
+
+	c := New()
+	c.SafeMode = true
+	c.CollectionMode = string(collectionModeFile)
+	c.CacheDir = dir
+	require.True(t, c.Init())
+	require.NotNil(t, c.Collect())
+	_, err := c.HandleFunction(context.Background(), nil)
+	assert.Error(t, err)
+	assert.Nil(t, c.exec, "no exec must be created in safe mode")
+
+	marker := filepath.Join(t.TempDir(), "spawned")
+	cfg := New().Config
+	cfg.SafeMode = true
+	e := newCcacheExec(context.Background(), "sh", cfg, nil)
+	_, err = e.execute("-c", "touch "+marker)
+	assert.ErrorIs(t, err, errSafeMode)
+	assert.NoFileExists(t, marker, "no process must be spawned in safe mode")
+}
+
+func Test_maybeDecompress(t *testing.T) {
+	bs, err := maybeDecompress(dataVer410PrintStatsJSONGzip)
+	require.NoError(t, err)
+	assert.Equal(t, dataVer410PrintStatsJSON, bs)
+
+	bs, err = maybeDecompress(dataVer48PrintStats)
+	require.NoError(t, err)
+	assert.Equal(t, dataVer48PrintStats, bs, "uncompressed data must be returned as is")
+
+	_, err = maybeDecompress(dataVer410PrintStatsJSONGzip[:len(dataVer410PrintStatsJSONGzip)/2])
+	var de *decompressError
+	assert.ErrorAs(t, err, &de, "truncated gzip data must be a decompress error")
+}
+
+func TestCcache_Collect_GzipStats(t *testing.T) {
+	c := New()
+	m := prepareMockVer410()
+	m.printStatsJSONData = dataVer410PrintStatsJSONGzip
+	c.exec = m
+	require.True(t, c.Init())
+
+	assert.Equal(t, expectedVer4Metrics, c.Collect())
+
+	m.printStatsJSONData = dataVer410PrintStatsJSONGzip[:10]
+	_, err := c.collect()
+	var de *decompressError
+	assert.ErrorAs(t, err, &de)
+}
+
+func TestCcache_Collect_DumpFile(t *testing.T) {
+	c := New()
+	c.DumpFile = filepath.Join(t.TempDir(), "ccache.jsonl")
+	c.exec = prepareMockVer410()
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+
+	bs, err := os.ReadFile(c.DumpFile)
+	require.NoError(t, err)
+	var rec dumpRecord
+	require.NoError(t, json.Unmarshal(bs, &rec))
+	assert.NotZero(t, rec.Timestamp)
+	assert.Equal(t, mx, rec.Metrics)
+
+	c.DumpFileMaxSize = int64(len(bs)) + 1
+	require.NotNil(t, c.Collect())
+	_, err = os.Stat(c.DumpFile + ".1")
+	assert.NoError(t, err)
+
+	c.DumpFile = filepath.Join(t.TempDir(), "missing", "ccache.jsonl")
+	assert.NotNil(t, c.Collect())
+	assert.True(t, c.dumpFailed)
+}
+
+func Test_parseStatsText(t *testing.T) {
+	want, err := parseStatsText(dataVer48PrintStats)
+	require.NoError(t, err)
+
+	got, err := parseStatsText(dataVer48PrintStatsDecorated)
+	require.NoError(t, err)
+
+	assert.Equal(t, want, got, "header/footer lines must not produce metrics")
+}
+
+func TestCcache_Collect_StatsKeyAliases(t *testing.T) {
+	canonical := "direct_cache_hit\t30\npreprocessed_cache_hit\t10\ncache_miss\t20\n" +
+		"local_storage_hit\t40\nlocal_storage_miss\t20\nremote_storage_hit\t5\nremote_storage_miss\t15\n"
+	aliased := "cache_hit_direct\t30\ncache_hit_preprocessed\t10\ncache_miss\t20\n" +
+		"primary_storage_hit\t40\nprimary_storage_miss\t20\nsecondary_storage_hit\t5\nsecondary_storage_miss\t15\n"
+
+	collect := func(data string) map[string]int64 {
+		c := New()
+		m := prepareMockVer48()
+		m.printStatsData = []byte(data)
+		c.exec = m
+		require.True(t, c.Init())
+		mx := c.Collect()
+		require.NotNil(t, mx)
+		return mx
+	}
+
+	want := collect(canonical)
+	assert.Equal(t, int64(30), want["direct_cache_hit"])
+	assert.Equal(t, int64(40), want["local_storage_hit"])
+	assert.Equal(t, want, collect(aliased), "both keys naming variants must produce the same metrics")
+
+	c := New()
+	c.CollectionMode = string(collectionModeNodes)
+	for name, data := range map[string]string{"canonical": canonical, "aliased": aliased} {
+		data := data
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(data))
+		}))
+		defer srv.Close()
+		c.Nodes = append(c.Nodes, NodeConfig{Name: name, HTTP: web.HTTP{Request: web.Request{URL: srv.URL}}})
+	}
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	assert.Equal(t, int64(2*30), mx["direct_cache_hit"], "the nodes keys naming variants must be summed")
+	assert.Equal(t, int64(2*40), mx["local_storage_hit"])
+	assert.Equal(t, int64(2*5), mx["remote_storage_hit"])
+}
+
+func Test_normalizeStatsKeys(t *testing.T) {
+	stats := map[string]int64{"cache_hit_direct": 1, "primary_storage_hit": 2, "local_storage_hit": 3, "cache_miss": 4}
+	normalizeStatsKeys(stats)
+
+	assert.Equal(t, map[string]int64{"direct_cache_hit": 1, "local_storage_hit": 3, "cache_miss": 4}, stats,
+		"the canonical key must win over its alias")
+}
+
+func Test_parseShowStats_Sections(t *testing.T) {
+	stats, sections, err := parseShowStats(dataVer48ShowStatsVerbose)
+	require.NoError(t, err)
+
+	want, err := parseStatsText(dataVer48PrintStats)
+	require.NoError(t, err)
+	for _, key := range []string{
+		"direct_cache_hit",
+		"preprocessed_cache_hit",
+		"cache_miss",
+		"called_for_link",
+		"compile_failed",
+		"unsupported_compiler_option",
+		"files_in_cache",
+		"cleanups_performed",
+		"local_storage_hit",
+		"local_storage_write",
+		"remote_storage_hit",
+		"remote_storage_error",
+		"remote_storage_timeout",
+	} {
+		assert.Equalf(t, want[key], stats[key], "key '%s'", key)
+	}
+	assert.Equal(t, int64(2_900_000_000), stats["cache_size_bytes"])
+	assert.Equal(t, int64(1), stats["shiny_new_error"])
+	assert.NotContains(t, stats, "stats_updated", "header lines must not be collected")
+
+	wantSections := map[string]statsSection{
+		"direct_cache_hit":            sectionCacheable,
+		"preprocessed_cache_hit":      sectionCacheable,
+		"cache_miss":                  sectionCacheable,
+		"called_for_link":             sectionUncacheable,
+		"compile_failed":              sectionUncacheable,
+		"could_not_find_compiler":     sectionErrors,
+		"shiny_new_error":             sectionErrors,
+		"files_in_cache":              sectionLocalStorage,
+		"local_storage_hit":           sectionLocalStorage,
+		"remote_storage_error":        sectionRemoteStorage,
+		"remote_storage_timeout":      sectionRemoteStorage,
+		"unsupported_compiler_option": sectionUncacheable,
+	}
+	for key, section := range wantSections {
+		assert.Equalf(t, section, sections[key], "key '%s'", key)
+	}
+
+	_, sections, err = parseShowStats(dataVer34ShowStats)
+	require.NoError(t, err)
+	assert.Empty(t, sections, "flat output has no sections")
+}
+
+func TestCcache_Collect_ShowStatsSections(t *testing.T) {
+	c := New()
+	m := prepareMockVer34()
+	m.showStatsData = dataVer48ShowStatsVerbose
+	c.exec = m
+	require.True(t, c.Init())
+
+	mx := c.Collect()
+	require.NotNil(t, mx)
+	testMetricsHasAllChartsDims(t, c, mx)
+
+	uncacheable := c.Charts().Get(uncacheableCallsChart.ID)
+	require.NotNil(t, uncacheable)
+	assert.True(t, uncacheable.HasDim("compile_failed"), "grouped by the 'Uncacheable calls' section")
+	assert.False(t, uncacheable.HasDim("could_not_find_compiler"))
+
+	errs := c.Charts().Get(errorsChart.ID)
+	require.NotNil(t, errs)
+	assert.True(t, errs.HasDim("could_not_find_compiler"))
+	assert.True(t, errs.HasDim("shiny_new_error"), "unknown labels are grouped by their section")
+	assert.False(t, errs.HasDim("compile_failed"))
+}
+
+func Test_parseStats_CRLFAndMixedWhitespace(t *testing.T) {
+	crlf := func(bs []byte) []byte { return bytes.ReplaceAll(bs, []byte("\n"), []byte("\r\n")) }
+
+	tests := map[string]struct {
+		parse     func([]byte) (map[string]int64, error)
+		data      []byte
+		wantEqual []byte
+	}{
+		"text": {
+			parse:     parseStatsText,
+			data:      dataVer48PrintStatsCRLF,
+			wantEqual: dataVer48PrintStats,
+		},
+		"legacy": {
+			parse:     parseStatsLegacy,
+			data:      dataVer34ShowStatsCRLF,
+			wantEqual: dataVer34ShowStats,
+		},
+		"json": {
+			parse:     parseStatsJSON,
+			data:      crlf(dataVer410PrintStatsJSON),
+			wantEqual: dataVer410PrintStatsJSON,
+		},
+		"stats file": {
+			parse: func(bs []byte) (map[string]int64, error) {
+				stats := make(map[string]int64)
+				return stats, parseStatsFile(bs, stats)
+			},
+			data:      []byte("0\r\n1 \r\n\t2\r\n3\t \r\n4\r\n"),
+			wantEqual: []byte("0\n1\n2\n3\n4\n"),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			want, err := test.parse(test.wantEqual)
+			require.NoError(t, err)
+			require.NotEmpty(t, want)
+
+			got, err := test.parse(test.data)
+			require.NoError(t, err)
+
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func Test_parseShowStats_CRLF(t *testing.T) {
+	want, wantSections, err := parseShowStats(dataVer48ShowStatsVerbose)
+	require.NoError(t, err)
+
+	got, gotSections, err := parseShowStats(dataVer48ShowStatsCRLF)
+	require.NoError(t, err)
+
+	assert.Equal(t, want, got)
+	assert.Equal(t, wantSections, gotSections)
+}
+
+func writeStatsFile(t *testing.T, path string, stats map[string]int64) {
+	counters := make([]string, len(statsFileCounters))
+	for i, key := range statsFileCounters {
+		counters[i] = fmt.Sprint(stats[key])
+	}
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(counters, "\n")+"\n"), 0644))
+}
+
+func testMetricsHasAllChartsDims(t *testing.T, c *Ccache, mx map[string]int64) {
+	for _, chart := range *c.Charts() {
+		if chart.Obsolete {
+			continue
+		}
+		for _, dim := range chart.Dims {
+			if len(mx) == 0 {
+				return
+			}
+			_, ok := mx[dim.ID]
+			assert.Truef(t, ok, "collected metrics has no data for dim '%s' chart '%s'", dim.ID, chart.ID)
+		}
+	}
+}
+
+var expectedVer4Metrics = map[string]int64{
+	"autoconf_test":                           0,
+	"avg_object_size_bytes":                   294379,
+	"bad_compiler_arguments":                  13,
+	"bad_input_file":                          0,
+	"bad_output_file":                         0,
+	"cache_hit_percentage":                    79001,
+	"cache_miss":                              1300,
+	"cache_miss_percentage":                   20998,
+	"cache_size":                              2895515648,
+	"cacheable_call_share":                    94605,
+	"called_for_link":                         230,
+	"called_for_preprocessing":                11,
+	"cleanup_per_write_ratio":                 0,
+	"cleanups_performed":                      4,
+	"compile_failed":                          27,
+	"compiler_check_failed":                   0,
+	"compiler_produced_empty_output":          0,
+	"compiler_produced_no_output":             0,
+	"compiler_produced_stdout":                0,
+	"could_not_find_compiler":                 0,
+	"could_not_use_modules":                   0,
+	"could_not_use_precompiled_header":        0,
+	"direct_cache_hit":                        4706,
+	"direct_cache_miss":                       1485,
+	"disabled":                                0,
+	"error_hashing_extra_file":                0,
+	"files_in_cache":                          9836,
+	"hit_rate_trend":                          0,
+	"internal_error":                          0,
+	"local_storage_hit":                       4891,
+	"local_storage_miss":                      1300,
+	"metrics_age_seconds":                     0,
+	"preprocessing_call_share":                168,
+	"cache_resets":                            0,
+	"store_retrieve_ratio":                    531,
+	"files_added_per_interval":                0,
+	"files_removed_per_interval":              0,
+	"estimated_io_saved_bytes":                0,
+	"collect_errors":                          0,
+	"consecutive_successful_collects":         1,
+	"consecutive_failed_collects":             0,
+	"missing_cache_file":                      0,
+	"modified_input_file":                     0,
+	"multiple_source_files":                   0,
+	"no_input_file":                           8,
+	"output_to_stdout":                        0,
+	"preprocessed_cache_hit":                  185,
+	"preprocessed_cache_miss":                 1300,
+	"preprocessor_error":                      6,
+	"recache":                                 0,
+	"recent_autoconf_test":                    0,
+	"recent_bad_compiler_arguments":           0,
+	"recent_cache_hit_percentage":             0,
+	"cache_effective":                         0,
+	"recent_cache_miss_percentage":            0,
+	"recent_called_for_link":                  0,
+	"recent_called_for_preprocessing":         0,
+	"recent_could_not_use_modules":            0,
+	"recent_could_not_use_precompiled_header": 0,
+	"recent_disabled":                         0,
+	"recent_modified_input_file":              0,
+	"recent_multiple_source_files":            0,
+	"recent_no_input_file":                    0,
+	"recent_output_to_stdout":                 0,
+	"recent_recache":                          0,
+	"recent_unsupported_code_directive":       0,
+	"recent_unsupported_compiler_option":      0,
+	"recent_unsupported_environment_variable": 0,
+	"recent_unsupported_source_language":      0,
+	"remote_storage_error":                    2,
+	"remote_storage_hit":                      120,
+	"remote_storage_miss":                     1180,
+	"remote_storage_timeout":                  1,
+	"remote_timeout_share":                    26,
+	"total_calls":                             6544,
+	"unsupported_code_directive":              0,
+	"unsupported_compiler_option":             91,
+	"unsupported_environment_variable":        0,
+	"unsupported_source_language":             0,
+}
+
+func prepareMockVer24() *mockCcacheExec {
+	return &mockCcacheExec{
+		versionData:   dataVer24Version,
+		helpData:      dataVer24Help,
+		showStatsData: dataVer34ShowStats,
+	}
+}
+
+func prepareMockVer34() *mockCcacheExec {
+	return &mockCcacheExec{
+		versionData:   dataVer34Version,
+		helpData:      dataVer34Help,
+		showStatsData: dataVer34ShowStats,
+	}
+}
+
+func prepareMockSccache07() *mockCcacheExec {
+	return &mockCcacheExec{
+		versionData:      dataSccache07Version,
+		sccacheStatsData: dataSccache07StatsJSON,
+	}
+}
+
+func prepareMockVer48() *mockCcacheExec {
+	return &mockCcacheExec{
+		versionData:    dataVer48Version,
+		helpData:       dataVer48Help,
+		printStatsData: dataVer48PrintStats,
+	}
+}
+
+func prepareMockVer410() *mockCcacheExec {
+	return &mockCcacheExec{
+		versionData:        dataVer410Version,
+		helpData:           dataVer410Help,
+		printStatsData:     dataVer48PrintStats,
+		printStatsJSONData: dataVer410PrintStatsJSON,
+	}
+}
+
+// mockCcacheExec mimics a ccache binary: a stats command is supported only if there is data for it.
+type mockCcacheExec struct {
+	errOnVersion bool
+	errOnHelp    bool
+	errOnStats   bool
+
+	sccacheStatsData []byte
+
+	versionData        []byte
+	helpData           []byte
+	printStatsJSONData []byte
+	printStatsData     []byte
+	showStatsData      []byte
+	printConfigData    []byte
+
+	statsCalls int
+	statsCmd   string
+}
+
+func (m *mockCcacheExec) sccacheStats() ([]byte, error) {
+	return m.stats("--show-stats --stats-format=json", m.sccacheStatsData)
+}
+
+func (m *mockCcacheExec) printConfig() ([]byte, error) {
+	if m.printConfigData == nil {
+		return nil, errors.New("mock.printConfig() error")
+	}
+	return m.printConfigData, nil
+}
+
+func (m *mockCcacheExec) version() ([]byte, error) {
+	if m.errOnVersion {
+		return nil, errors.New("mock.version() error")
+	}
+	return m.versionData, nil
+}
+
+func (m *mockCcacheExec) help() ([]byte, error) {
+	if m.errOnHelp {
+		return nil, errors.New("mock.help() error")
+	}
+	return m.helpData, nil
+}
+
+func (m *mockCcacheExec) printStatsJSON() ([]byte, error) {
+	return m.stats("--print-stats --format=json", m.printStatsJSONData)
+}
+
+func (m *mockCcacheExec) printStats() ([]byte, error) {
+	return m.stats("--print-stats", m.printStatsData)
+}
+
+func (m *mockCcacheExec) showStats(flag string) ([]byte, error) {
+	return m.stats(flag, m.showStatsData)
+}
+
+func (m *mockCcacheExec) stats(cmd string, data []byte) ([]byte, error) {
+	m.statsCalls++
+	m.statsCmd = cmd
+	if m.errOnStats {
+		return nil, fmt.Errorf("mock '%s' error", cmd)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("mock '%s' is not supported", cmd)
+	}
+	return data, nil
+}
@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+//go:build linux
+// +build linux
+
+package ccache
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// checkCgroup checks the path is a cgroup v2 directory the processes can be placed into.
+func checkCgroup(path string) error {
+	if !isDir(path) {
+		return errors.New("not a directory")
+	}
+	if _, err := os.Stat(filepath.Join(path, "cgroup.procs")); err != nil {
+		return errors.New("not a cgroup v2 directory (no cgroup.procs)")
+	}
+	return nil
+}
+
+// setCmdCgroup makes the command start in the cgroup (clone3 CLONE_INTO_CGROUP, Linux 5.7+). The returned function
+// closes the cgroup directory, it is called after the command is done.
+func setCmdCgroup(cmd *exec.Cmd, path string) (func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(f.Fd())}
+	return func() { _ = f.Close() }, nil
+}
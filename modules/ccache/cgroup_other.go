@@ -0,0 +1,20 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+//go:build !linux
+// +build !linux
+
+package ccache
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+func checkCgroup(string) error {
+	return fmt.Errorf("cgroups are not supported on %s", runtime.GOOS)
+}
+
+func setCmdCgroup(*exec.Cmd, string) (func(), error) {
+	return nil, fmt.Errorf("cgroups are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+//go:build linux
+// +build linux
+
+package ccache
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCcache_Init_Cgroup(t *testing.T) {
+	notCgroup := t.TempDir()
+	fakeCgroup := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(fakeCgroup, "cgroup.procs"), nil, 0644))
+
+	tests := map[string]struct {
+		cgroup     string
+		wantCgroup string
+	}{
+		"not set":             {},
+		"missing directory":   {cgroup: filepath.Join(notCgroup, "missing")},
+		"not a cgroup v2 dir": {cgroup: notCgroup},
+		"cgroup v2 dir":       {cgroup: fakeCgroup, wantCgroup: fakeCgroup},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+			c.Cgroup = test.cgroup
+			c.exec = prepareMockVer48()
+
+			require.True(t, c.Init(), "an invalid cgroup must not fail the job")
+			assert.Equal(t, test.wantCgroup, c.Cgroup)
+		})
+	}
+}
+
+func Test_ccacheExec_cgroupPlacementFails(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	// passes the Init() check, but it isn't a cgroup: the process can't be placed into it
+	fakeCgroup := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(fakeCgroup, "cgroup.procs"), nil, 0644))
+
+	cfg := New().Config
+	cfg.Cgroup = fakeCgroup
+	e := newCcacheExec(context.Background(), sh, cfg, nil)
+
+	bs, err := e.execute("-c", "echo ok")
+	require.NoError(t, err)
+	assert.Equal(t, "ok\n", string(bs))
+	assert.Empty(t, e.cgroup.get(), "the cgroup must be dropped after a failed placement")
+
+	_, err = e.execute("-c", "exit 3")
+	var ee *execError
+	require.ErrorAs(t, err, &ee)
+	assert.Equal(t, 3, ee.exitCode)
+}
@@ -0,0 +1,932 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/netdata/go.d.plugin/agent/module"
+)
+
+const (
+	prioCcacheHits = module.Priority + iota
+	prioCcacheMisses
+	prioCcacheMissesByMode
+	prioCcacheTotalCalls
+	prioCcacheHitRatio
+	prioCcacheCacheableCallShare
+	prioCcachePreprocessingCallShare
+	prioCcacheCallsPerBuild
+	prioCcacheStoreRetrieveRatio
+	prioCcacheRecentHitRatio
+	prioCcacheCacheEffective
+	prioCcacheHitRateTrend
+	prioCcacheRecentMissReasons
+	prioCcacheSinceStartCalls
+	prioCcacheSinceStartHitRatio
+	prioCcacheUncacheableCalls
+	prioCcacheUnsupportedOptions
+	prioCcacheErrors
+	prioCcacheLocalStorage
+	prioCcacheRemoteStorage
+	prioCcacheRemoteStorageErrors
+	prioCcacheRemoteStorageTimeoutShare
+	prioCcacheCacheSize
+	prioCcacheCacheGrowth
+	prioCcacheFilesInCache
+	prioCcacheFileCountDiscrepancy
+	prioCcacheCacheChurn
+	prioCcacheAvgObjectSize
+	prioCcacheEstimatedIOSaved
+	prioCcacheEstimatedTimeSaved
+	prioCcacheStatLatency
+	prioCcacheCleanups
+	prioCcacheEvictionPressure
+	prioCcacheLastCleanup
+	prioCcacheSloppiness
+	prioCcacheMetricsAge
+	prioCcacheMirrorAge
+	prioCcacheCollectionHealth
+	prioCcacheVersionMatchesExpected
+	prioCcacheCollectionStreaks
+	prioCcacheShardBalance
+	prioCcacheStatsFiles
+	prioCcacheRawStats
+	prioCcacheNodeCalls
+	prioCcacheCacheDirCalls
+)
+
+var baseCharts = module.Charts{
+	hitsChart.Copy(),
+	missesChart.Copy(),
+	totalCallsChart.Copy(),
+	hitRatioChart.Copy(),
+	cacheableCallShareChart.Copy(),
+	storeRetrieveRatioChart.Copy(),
+	recentHitRatioChart.Copy(),
+	cacheEffectiveChart.Copy(),
+	hitRateTrendChart.Copy(),
+	cacheSizeChart.Copy(),
+	filesInCacheChart.Copy(),
+	cacheChurnChart.Copy(),
+	avgObjectSizeChart.Copy(),
+	estimatedIOSavedChart.Copy(),
+	cleanupsChart.Copy(),
+	metricsAgeChart.Copy(),
+	collectionHealthChart.Copy(),
+	collectionStreaksChart.Copy(),
+}
+
+var (
+	hitsChart = module.Chart{
+		ID:       "cache_hits",
+		Title:    "Cache hits",
+		Units:    "hits/s",
+		Fam:      "calls",
+		Ctx:      "ccache.cache_hits",
+		Priority: prioCcacheHits,
+		Type:     module.Stacked,
+		Dims: module.Dims{
+			{ID: "direct_cache_hit", Name: "direct", Algo: module.Incremental},
+			{ID: "preprocessed_cache_hit", Name: "preprocessed", Algo: module.Incremental},
+		},
+	}
+	missesChart = module.Chart{
+		ID:       "cache_misses",
+		Title:    "Cache misses",
+		Units:    "misses/s",
+		Fam:      "calls",
+		Ctx:      "ccache.cache_misses",
+		Priority: prioCcacheMisses,
+		Dims: module.Dims{
+			{ID: "cache_miss", Name: "miss", Algo: module.Incremental},
+		},
+	}
+	missesByModeChart = module.Chart{
+		ID:       "cache_misses_by_mode",
+		Title:    "Cache misses by mode",
+		Units:    "misses/s",
+		Fam:      "calls",
+		Ctx:      "ccache.cache_misses_by_mode",
+		Priority: prioCcacheMissesByMode,
+		Type:     module.Stacked,
+		Dims: module.Dims{
+			{ID: "direct_cache_miss", Name: "direct", Algo: module.Incremental},
+			{ID: "preprocessed_cache_miss", Name: "preprocessed", Algo: module.Incremental},
+		},
+	}
+	totalCallsChart = module.Chart{
+		ID:       "total_calls",
+		Title:    "Total calls (hits, misses and uncacheable calls)",
+		Units:    "calls/s",
+		Fam:      "calls",
+		Ctx:      "ccache.total_calls",
+		Priority: prioCcacheTotalCalls,
+		Dims: module.Dims{
+			{ID: "total_calls", Name: "calls", Algo: module.Incremental},
+		},
+	}
+	hitRatioChart = module.Chart{
+		ID:       "cache_hit_ratio",
+		Title:    "Cache hit ratio",
+		Units:    "percentage",
+		Fam:      "calls",
+		Ctx:      "ccache.cache_hit_ratio",
+		Priority: prioCcacheHitRatio,
+		Type:     module.Stacked,
+		Dims: module.Dims{
+			{ID: "cache_hit_percentage", Name: "hit", Div: precision},
+			{ID: "cache_miss_percentage", Name: "miss", Div: precision},
+			{ID: "cache_uncacheable_percentage", Name: "uncacheable", Div: precision},
+		},
+	}
+	cacheableCallShareChart = module.Chart{
+		ID:       "cacheable_call_share",
+		Title:    "Cacheable calls share (hits and misses of the total calls)",
+		Units:    "percentage",
+		Fam:      "calls",
+		Ctx:      "ccache.cacheable_call_share",
+		Priority: prioCcacheCacheableCallShare,
+		Dims: module.Dims{
+			{ID: "cacheable_call_share", Name: "cacheable", Div: precision},
+		},
+	}
+	preprocessingCallShareChart = module.Chart{
+		ID:       "preprocessing_call_share",
+		Title:    "Calls for separate preprocessing share (of the total calls)",
+		Units:    "percentage",
+		Fam:      "calls",
+		Ctx:      "ccache.preprocessing_call_share",
+		Priority: prioCcachePreprocessingCallShare,
+		Dims: module.Dims{
+			{ID: "preprocessing_call_share", Name: "preprocessing", Div: precision},
+		},
+	}
+	storeRetrieveRatioChart = module.Chart{
+		ID:       "store_retrieve_ratio",
+		Title:    "Objects stored per object retrieved",
+		Units:    "stores/retrieval",
+		Fam:      "calls",
+		Ctx:      "ccache.store_retrieve_ratio",
+		Priority: prioCcacheStoreRetrieveRatio,
+		Dims: module.Dims{
+			{ID: "store_retrieve_ratio", Name: "ratio", Div: precision},
+		},
+	}
+	recentHitRatioChart = module.Chart{
+		ID:       "recent_cache_hit_ratio",
+		Title:    "Cache hit ratio during the last collection interval",
+		Units:    "percentage",
+		Fam:      "calls",
+		Ctx:      "ccache.recent_cache_hit_ratio",
+		Priority: prioCcacheRecentHitRatio,
+		Type:     module.Stacked,
+		Dims: module.Dims{
+			{ID: "recent_cache_hit_percentage", Name: "hit", Div: precision},
+			{ID: "recent_cache_miss_percentage", Name: "miss", Div: precision},
+			{ID: "recent_cache_uncacheable_percentage", Name: "uncacheable", Div: precision},
+		},
+	}
+	cacheEffectiveChart = module.Chart{
+		ID:       "cache_effective",
+		Title:    "Cache effective (recent hit ratio above the threshold and cache used)",
+		Units:    "boolean",
+		Fam:      "calls",
+		Ctx:      "ccache.cache_effective",
+		Priority: prioCcacheCacheEffective,
+		Dims: module.Dims{
+			{ID: "cache_effective", Name: "effective"},
+		},
+	}
+	hitRateTrendChart = module.Chart{
+		ID:       "hit_rate_trend",
+		Title:    "Cache hit ratio trend (-1 down, 0 flat, 1 up)",
+		Units:    "trend",
+		Fam:      "calls",
+		Ctx:      "ccache.hit_rate_trend",
+		Priority: prioCcacheHitRateTrend,
+		Dims: module.Dims{
+			{ID: "hit_rate_trend", Name: "trend"},
+		},
+	}
+	recentMissReasonsChart = module.Chart{
+		ID:       "recent_miss_reasons",
+		Title:    "Uncacheable calls during the last collection interval",
+		Units:    "calls",
+		Fam:      "calls",
+		Ctx:      "ccache.recent_miss_reasons",
+		Priority: prioCcacheRecentMissReasons,
+		Type:     module.Stacked,
+	}
+	uncacheableCallsChart = module.Chart{
+		ID:       "uncacheable_calls",
+		Title:    "Uncacheable calls",
+		Units:    "calls/s",
+		Fam:      "calls",
+		Ctx:      "ccache.uncacheable_calls",
+		Priority: prioCcacheUncacheableCalls,
+		Type:     module.Stacked,
+	}
+	unsupportedOptionsChart = module.Chart{
+		ID:       "unsupported_options",
+		Title:    "Uncacheable calls due to unsupported compiler options and code directives",
+		Units:    "calls/s",
+		Fam:      "calls",
+		Ctx:      "ccache.unsupported_options",
+		Priority: prioCcacheUnsupportedOptions,
+		Type:     module.Stacked,
+		Dims: module.Dims{
+			{ID: "unsupported_compiler_option", Name: "compiler_option", Algo: module.Incremental},
+			{ID: "unsupported_code_directive", Name: "code_directive", Algo: module.Incremental},
+		},
+	}
+	errorsChart = module.Chart{
+		ID:       "errors",
+		Title:    "Errors",
+		Units:    "errors/s",
+		Fam:      "errors",
+		Ctx:      "ccache.errors",
+		Priority: prioCcacheErrors,
+		Type:     module.Stacked,
+	}
+)
+
+var (
+	sinceStartCallsChart = module.Chart{
+		ID:       "since_start_calls",
+		Title:    "Cache hits and misses since the job start",
+		Units:    "calls",
+		Fam:      "since start",
+		Ctx:      "ccache.since_start_calls",
+		Priority: prioCcacheSinceStartCalls,
+		Type:     module.Stacked,
+		Dims: module.Dims{
+			{ID: "since_start_direct_cache_hit", Name: "direct_hit"},
+			{ID: "since_start_preprocessed_cache_hit", Name: "preprocessed_hit"},
+			{ID: "since_start_cache_miss", Name: "miss"},
+		},
+	}
+	sinceStartHitRatioChart = module.Chart{
+		ID:       "since_start_cache_hit_ratio",
+		Title:    "Cache hit ratio since the job start",
+		Units:    "percentage",
+		Fam:      "since start",
+		Ctx:      "ccache.since_start_cache_hit_ratio",
+		Priority: prioCcacheSinceStartHitRatio,
+		Type:     module.Stacked,
+		Dims: module.Dims{
+			{ID: "since_start_cache_hit_percentage", Name: "hit", Div: precision},
+			{ID: "since_start_cache_miss_percentage", Name: "miss", Div: precision},
+			{ID: "since_start_cache_uncacheable_percentage", Name: "uncacheable", Div: precision},
+		},
+	}
+)
+
+var (
+	localStorageChart = module.Chart{
+		ID:       "local_storage",
+		Title:    "Local Storage Hits/Misses",
+		Units:    "events/s",
+		Fam:      "storage",
+		Ctx:      "ccache.local_storage",
+		Priority: prioCcacheLocalStorage,
+		Type:     module.Stacked,
+		Dims: module.Dims{
+			{ID: "local_storage_hit", Name: "hit", Algo: module.Incremental},
+			{ID: "local_storage_miss", Name: "miss", Algo: module.Incremental},
+		},
+	}
+	remoteStorageChart = module.Chart{
+		ID:       "remote_storage",
+		Title:    "Remote Storage Hits/Misses",
+		Units:    "events/s",
+		Fam:      "storage",
+		Ctx:      "ccache.remote_storage",
+		Priority: prioCcacheRemoteStorage,
+		Type:     module.Stacked,
+		Dims: module.Dims{
+			{ID: "remote_storage_hit", Name: "hit", Algo: module.Incremental},
+			{ID: "remote_storage_miss", Name: "miss", Algo: module.Incremental},
+		},
+	}
+	remoteStorageErrorsChart = module.Chart{
+		ID:       "remote_storage_errors",
+		Title:    "Remote Storage Errors",
+		Units:    "errors/s",
+		Fam:      "storage",
+		Ctx:      "ccache.remote_storage_errors",
+		Priority: prioCcacheRemoteStorageErrors,
+		Dims: module.Dims{
+			{ID: "remote_storage_error", Name: "error", Algo: module.Incremental},
+			{ID: "remote_storage_timeout", Name: "timeout", Algo: module.Incremental},
+		},
+	}
+	remoteStorageTimeoutShareChart = module.Chart{
+		ID:       "remote_storage_timeout_share",
+		Title:    "Remote Storage Timeouts share of operations",
+		Units:    "percentage",
+		Fam:      "storage",
+		Ctx:      "ccache.remote_storage_timeout_share",
+		Priority: prioCcacheRemoteStorageTimeoutShare,
+		Dims: module.Dims{
+			{ID: "remote_timeout_share", Name: "timeouts", Div: precision},
+		},
+	}
+)
+
+var (
+	// Cache size is reported in bytes with no Mul/Div, the dashboard scales it (KiB, MiB, GiB) by itself.
+	cacheSizeChart = module.Chart{
+		ID:       "cache_size",
+		Title:    "Cache size",
+		Units:    "bytes",
+		Fam:      "cache",
+		Ctx:      "ccache.cache_size",
+		Priority: prioCcacheCacheSize,
+		Type:     module.Area,
+		Dims: module.Dims{
+			{ID: "cache_size", Name: "size"},
+		},
+	}
+	cacheGrowthChart = module.Chart{
+		ID:       "cache_growth",
+		Title:    "Cache growth rate",
+		Units:    "bytes/day",
+		Fam:      "cache",
+		Ctx:      "ccache.cache_growth",
+		Priority: prioCcacheCacheGrowth,
+		Dims: module.Dims{
+			{ID: "cache_growth_bytes_per_day", Name: "growth"},
+		},
+	}
+	filesInCacheChart = module.Chart{
+		ID:       "files_in_cache",
+		Title:    "Files in cache",
+		Units:    "files",
+		Fam:      "cache",
+		Ctx:      "ccache.files_in_cache",
+		Priority: prioCcacheFilesInCache,
+		Dims: module.Dims{
+			{ID: "files_in_cache", Name: "files"},
+		},
+	}
+	cacheChurnChart = module.Chart{
+		ID:       "cache_churn",
+		Title:    "Files added to and removed from the cache per collection interval",
+		Units:    "files",
+		Fam:      "cache",
+		Ctx:      "ccache.cache_churn",
+		Priority: prioCcacheCacheChurn,
+		Dims: module.Dims{
+			{ID: "files_added_per_interval", Name: "added"},
+			{ID: "files_removed_per_interval", Name: "removed", Mul: -1},
+		},
+	}
+	avgObjectSizeChart = module.Chart{
+		ID:       "avg_object_size",
+		Title:    "Average cached object size",
+		Units:    "bytes",
+		Fam:      "cache",
+		Ctx:      "ccache.avg_object_size",
+		Priority: prioCcacheAvgObjectSize,
+		Dims: module.Dims{
+			{ID: "avg_object_size_bytes", Name: "avg"},
+		},
+	}
+	estimatedIOSavedChart = module.Chart{
+		ID:       "estimated_io_saved",
+		Title:    "Estimated disk I/O saved by cache hits",
+		Units:    "bytes/s",
+		Fam:      "cache",
+		Ctx:      "ccache.estimated_io_saved",
+		Priority: prioCcacheEstimatedIOSaved,
+		Type:     module.Area,
+		Dims: module.Dims{
+			{ID: "estimated_io_saved_bytes", Name: "saved", Algo: module.Incremental},
+		},
+	}
+	cleanupsChart = module.Chart{
+		ID:       "cleanups",
+		Title:    "Cache cleanups",
+		Units:    "cleanups/s",
+		Fam:      "cache",
+		Ctx:      "ccache.cleanups",
+		Priority: prioCcacheCleanups,
+		Dims: module.Dims{
+			{ID: "cleanups_performed", Name: "cleanups", Algo: module.Incremental},
+		},
+	}
+	evictionPressureChart = module.Chart{
+		ID:       "eviction_pressure",
+		Title:    "Cache cleanups per cache write",
+		Units:    "cleanups/write",
+		Fam:      "cache",
+		Ctx:      "ccache.eviction_pressure",
+		Priority: prioCcacheEvictionPressure,
+		Dims: module.Dims{
+			{ID: "cleanup_per_write_ratio", Name: "ratio", Div: precision},
+		},
+	}
+	metricsAgeChart = module.Chart{
+		ID:       "metrics_age",
+		Title:    "Time since the stats were last fetched",
+		Units:    "seconds",
+		Fam:      "collection",
+		Ctx:      "ccache.metrics_age",
+		Priority: prioCcacheMetricsAge,
+		Dims: module.Dims{
+			{ID: "metrics_age_seconds", Name: "age"},
+		},
+	}
+	mirrorAgeChart = module.Chart{
+		ID:       "mirror_age",
+		Title:    "Time since the stats mirror was last updated",
+		Units:    "seconds",
+		Fam:      "collection",
+		Ctx:      "ccache.mirror_age",
+		Priority: prioCcacheMirrorAge,
+		Dims: module.Dims{
+			{ID: "mirror_age_seconds", Name: "age"},
+		},
+	}
+	collectionHealthChart = module.Chart{
+		ID:       "collection_health",
+		Title:    "Failed collections and cache stats resets",
+		Units:    "events/s",
+		Fam:      "collection",
+		Ctx:      "ccache.collection_health",
+		Priority: prioCcacheCollectionHealth,
+		Dims: module.Dims{
+			{ID: "collect_errors", Name: "errors", Algo: module.Incremental},
+			{ID: "cache_resets", Name: "resets", Algo: module.Incremental},
+		},
+	}
+	collectionStreaksChart = module.Chart{
+		ID:       "collection_streaks",
+		Title:    "Consecutive successful and failed collections",
+		Units:    "collections",
+		Fam:      "collection",
+		Ctx:      "ccache.collection_streaks",
+		Priority: prioCcacheCollectionStreaks,
+		Dims: module.Dims{
+			{ID: "consecutive_successful_collects", Name: "successful"},
+			{ID: "consecutive_failed_collects", Name: "failed"},
+		},
+	}
+	lastCleanupChart = module.Chart{
+		ID:       "time_since_last_cleanup",
+		Title:    "Time since the last cache cleanup",
+		Units:    "seconds",
+		Fam:      "cache",
+		Ctx:      "ccache.time_since_last_cleanup",
+		Priority: prioCcacheLastCleanup,
+		Dims: module.Dims{
+			{ID: "seconds_since_last_cleanup", Name: "time"},
+		},
+	}
+)
+
+var shardBalanceChart = module.Chart{
+	ID:       "shard_balance",
+	Title:    "Cache files distribution across shard directories",
+	Units:    "files",
+	Fam:      "cache",
+	Ctx:      "ccache.shard_balance",
+	Priority: prioCcacheShardBalance,
+	Dims: module.Dims{
+		{ID: "shard_files_min", Name: "min"},
+		{ID: "shard_files_max", Name: "max"},
+		{ID: "shard_files_stddev", Name: "stddev", Div: precision},
+	},
+}
+
+var fileCountDiscrepancyChart = module.Chart{
+	ID:       "file_count_discrepancy",
+	Title:    "Cache files on disk minus the reported files in cache",
+	Units:    "files",
+	Fam:      "cache",
+	Ctx:      "ccache.file_count_discrepancy",
+	Priority: prioCcacheFileCountDiscrepancy,
+	Dims: module.Dims{
+		{ID: "file_count_discrepancy", Name: "discrepancy"},
+	},
+}
+
+var statsFilesChart = module.Chart{
+	ID:       "stats_files",
+	Title:    "Stats files read",
+	Units:    "files",
+	Fam:      "collection",
+	Ctx:      "ccache.stats_files",
+	Priority: prioCcacheStatsFiles,
+	Dims: module.Dims{
+		{ID: "stats_files_scanned", Name: "scanned"},
+		{ID: "stats_files_read_errors", Name: "read_errors"},
+	},
+}
+
+var estimatedTimeSavedChart = module.Chart{
+	ID:       "estimated_time_saved",
+	Title:    "Estimated share of the compilation time saved by cache hits",
+	Units:    "percentage",
+	Fam:      "cache",
+	Ctx:      "ccache.estimated_time_saved",
+	Priority: prioCcacheEstimatedTimeSaved,
+	Dims: module.Dims{
+		{ID: "estimated_time_saved_percent", Name: "saved", Div: precision},
+	},
+}
+
+var callsPerBuildChart = module.Chart{
+	ID:       "calls_per_build",
+	Title:    "Cache hits and misses per build",
+	Units:    "calls/build",
+	Fam:      "calls",
+	Ctx:      "ccache.calls_per_build",
+	Priority: prioCcacheCallsPerBuild,
+	Dims: module.Dims{
+		{ID: "hits_per_build", Name: "hits", Div: precision},
+		{ID: "misses_per_build", Name: "misses", Div: precision},
+	},
+}
+
+var versionMatchesExpectedChart = module.Chart{
+	ID:       "version_matches_expected",
+	Title:    "Binary version matches the expected version",
+	Units:    "boolean",
+	Fam:      "collection",
+	Ctx:      "ccache.version_matches_expected",
+	Priority: prioCcacheVersionMatchesExpected,
+	Dims: module.Dims{
+		{ID: "version_matches_expected", Name: "matches"},
+	},
+}
+
+var sloppinessChart = module.Chart{
+	ID:       "sloppiness",
+	Title:    "Enabled sloppiness flags",
+	Units:    "flags",
+	Fam:      "cache",
+	Ctx:      "ccache.sloppiness",
+	Priority: prioCcacheSloppiness,
+	Dims: module.Dims{
+		{ID: "sloppiness_flags", Name: "enabled"},
+	},
+}
+
+var statLatencyChart = module.Chart{
+	ID:       "cache_dir_stat_latency",
+	Title:    "Cache directory stat latency",
+	Units:    "milliseconds",
+	Fam:      "cache",
+	Ctx:      "ccache.cache_dir_stat_latency",
+	Priority: prioCcacheStatLatency,
+	Dims: module.Dims{
+		{ID: "cache_dir_stat_latency_ms", Name: "latency", Div: precision},
+	},
+}
+
+var rawStatsChart = module.Chart{
+	ID:       "raw_stats",
+	Title:    "Raw stats",
+	Units:    "value",
+	Fam:      "raw",
+	Ctx:      "ccache.raw_stats",
+	Priority: prioCcacheRawStats,
+}
+
+var nodeCallsChartTmpl = module.Chart{
+	ID:       "node_%s_calls",
+	Title:    "Node cache hits and misses",
+	Units:    "calls/s",
+	Fam:      "nodes",
+	Ctx:      "ccache.node_calls",
+	Priority: prioCcacheNodeCalls,
+	Type:     module.Stacked,
+	Dims: module.Dims{
+		{ID: "node_%s_cache_hit", Name: "hit", Algo: module.Incremental},
+		{ID: "node_%s_cache_miss", Name: "miss", Algo: module.Incremental},
+	},
+}
+
+func (c *Ccache) addNodeCharts(node *cacheNode) {
+	chart := nodeCallsChartTmpl.Copy()
+	chart.ID = fmt.Sprintf(chart.ID, node.id)
+	chart.Labels = []module.Label{
+		{Key: "node", Value: node.name},
+	}
+	for _, dim := range chart.Dims {
+		dim.ID = fmt.Sprintf(dim.ID, node.id)
+	}
+
+	if err := c.addCharts(chart); err != nil {
+		c.Warning(err)
+	}
+}
+
+var cacheDirCallsChartTmpl = module.Chart{
+	ID:       "cache_dir_%s_calls",
+	Title:    "Cache directory cache hits and misses",
+	Units:    "calls/s",
+	Fam:      "cache dirs",
+	Ctx:      "ccache.cache_dir_calls",
+	Priority: prioCcacheCacheDirCalls,
+	Type:     module.Stacked,
+	Dims: module.Dims{
+		{ID: "cache_dir_%s_cache_hit", Name: "hit", Algo: module.Incremental},
+		{ID: "cache_dir_%s_cache_miss", Name: "miss", Algo: module.Incremental},
+	},
+}
+
+func (c *Ccache) addCacheDirCharts(name, dir string) {
+	chart := cacheDirCallsChartTmpl.Copy()
+	chart.ID = fmt.Sprintf(chart.ID, name)
+	chart.Labels = []module.Label{
+		{Key: "cache_dir", Value: dir},
+	}
+	for _, dim := range chart.Dims {
+		dim.ID = fmt.Sprintf(dim.ID, name)
+	}
+
+	if err := c.addCharts(chart); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) removeCacheDirCharts(name string) {
+	if chart := c.Charts().Get(fmt.Sprintf(cacheDirCallsChartTmpl.ID, name)); chart != nil {
+		chart.MarkRemove()
+		chart.MarkNotCreated()
+	}
+}
+
+func (c *Ccache) addShardBalanceCharts() {
+	if err := c.addCharts(shardBalanceChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addFileCountDiscrepancyCharts() {
+	if err := c.addCharts(fileCountDiscrepancyChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addStatsFilesCharts() {
+	if err := c.addCharts(statsFilesChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addEstimatedTimeSavedCharts() {
+	if err := c.addCharts(estimatedTimeSavedChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addCallsPerBuildCharts() {
+	if err := c.addCharts(callsPerBuildChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addVersionMatchesExpectedCharts() {
+	if err := c.addCharts(versionMatchesExpectedChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addSloppinessCharts() {
+	if err := c.addCharts(sloppinessChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addStatLatencyCharts() {
+	if err := c.addCharts(statLatencyChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addLastCleanupCharts() {
+	if err := c.addCharts(lastCleanupChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addCacheGrowthCharts() {
+	if err := c.addCharts(cacheGrowthChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addPreprocessingCallShareCharts() {
+	if err := c.addCharts(preprocessingCallShareChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addMissesByModeCharts() {
+	if err := c.addCharts(missesByModeChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addEvictionPressureCharts() {
+	if err := c.addCharts(evictionPressureChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addUnsupportedOptionsCharts() {
+	if err := c.addCharts(unsupportedOptionsChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addMirrorAgeCharts() {
+	if err := c.addCharts(mirrorAgeChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addLocalStorageCharts() {
+	if err := c.addCharts(localStorageChart.Copy()); err != nil {
+		c.Warning(err)
+	}
+}
+
+// percentageCharts are the hit/miss percentage charts, their type is configurable ('percentage_chart_type').
+var percentageCharts = []string{
+	hitRatioChart.ID,
+	recentHitRatioChart.ID,
+	sinceStartHitRatioChart.ID,
+}
+
+// applyHitRateBasis removes the uncacheable dimension of the percentage charts with the 'hits_misses' basis,
+// the hit and miss percentages add up to 100 without it.
+func (c *Ccache) applyHitRateBasis() {
+	if c.hitRateBasis() != hitRateBasisHitsMisses {
+		return
+	}
+	for _, id := range percentageCharts {
+		chart := c.Charts().Get(id)
+		if chart == nil {
+			continue
+		}
+		for _, dim := range slices.Clone(chart.Dims) {
+			if strings.HasSuffix(dim.ID, "cache_uncacheable_percentage") {
+				_ = chart.RemoveDim(dim.ID)
+			}
+		}
+	}
+}
+
+func (c *Ccache) setPercentageChartsType() {
+	typ := module.ChartType(c.PercentageChartType)
+	switch typ {
+	case module.Stacked, module.Line:
+	default:
+		c.Warningf("unknown 'percentage_chart_type' '%s' (supported: '%s', '%s'), using '%s'",
+			c.PercentageChartType, module.Stacked, module.Line, module.Stacked)
+		typ = module.Stacked
+	}
+
+	for _, id := range percentageCharts {
+		if chart := c.Charts().Get(id); chart != nil {
+			chart.Type = typ
+		}
+	}
+}
+
+func (c *Ccache) addSinceStartCharts() {
+	charts := module.Charts{
+		sinceStartCallsChart.Copy(),
+		sinceStartHitRatioChart.Copy(),
+	}
+
+	if err := c.addCharts(charts...); err != nil {
+		c.Warning(err)
+	}
+}
+
+func (c *Ccache) addRemoteStorageCharts() {
+	charts := module.Charts{
+		remoteStorageChart.Copy(),
+		remoteStorageErrorsChart.Copy(),
+		remoteStorageTimeoutShareChart.Copy(),
+	}
+
+	if err := c.addCharts(charts...); err != nil {
+		c.Warning(err)
+	}
+}
+
+// addCharts adds the charts with their priorities shifted by the 'priority' option offset.
+func (c *Ccache) addCharts(charts ...*module.Chart) error {
+	var added []*module.Chart
+	for _, chart := range charts {
+		c.adjustChart(chart)
+		if c.suppressDims(chart) {
+			added = append(added, chart)
+		}
+	}
+	return c.Charts().Add(added...)
+}
+
+// adjustChart applies the job level charts settings: the 'priority' shift, the 'context_prefix',
+// the 'title_prefix' and the 'family' override.
+func (c *Ccache) adjustChart(chart *module.Chart) {
+	chart.Priority += c.priorityOffset
+	if c.ContextPrefix != "" {
+		chart.Ctx = c.ContextPrefix + "." + chart.Ctx
+	}
+	if c.TitlePrefix != "" {
+		chart.Title = c.TitlePrefix + ": " + chart.Title
+	}
+	if c.Family != "" {
+		chart.Fam = c.Family
+	}
+}
+
+// addDimToChart adds a dynamic dimension, creating the chart from the template if needed. The dimensions are kept
+// sorted by ID, so their order (and the stacked charts colors) doesn't depend on the stats order or on when
+// a stat first appeared.
+func (c *Ccache) addDimToChart(tmpl *module.Chart, dim *module.Dim) {
+	if c.suppressedMetrics[dim.ID] {
+		return
+	}
+	chart := c.Charts().Get(tmpl.ID)
+	if chart == nil {
+		chart = tmpl.Copy()
+		if err := c.addCharts(chart); err != nil {
+			c.Warning(err)
+			return
+		}
+	}
+
+	if err := chart.AddDim(dim); err != nil {
+		c.Warning(err)
+		return
+	}
+	sort.SliceStable(chart.Dims, func(i, j int) bool { return chart.Dims[i].ID < chart.Dims[j].ID })
+	chart.MarkNotCreated()
+}
+
+// updateChartsLabels sets the job level labels (ccache version, build identity) on all the charts,
+// including the ones added after the previous update.
+func (c *Ccache) updateChartsLabels() {
+	var labels []module.Label
+	if c.version != "" {
+		labels = append(labels, module.Label{Key: "ccache_version", Value: c.version})
+	}
+	if c.buildID != "" {
+		labels = append(labels, module.Label{Key: "build_id", Value: c.buildID})
+	}
+	if c.distributedCompiler != "" {
+		labels = append(labels, module.Label{Key: "distributed_compiler", Value: c.distributedCompiler})
+	}
+	if c.sloppiness != nil {
+		value := strings.Join(c.sloppiness, ",")
+		if value == "" {
+			value = "none"
+		}
+		labels = append(labels, module.Label{Key: "sloppiness", Value: value})
+	}
+
+	for _, chart := range *c.Charts() {
+		var changed bool
+		for _, lbl := range labels {
+			changed = setChartLabel(chart, lbl) || changed
+		}
+		if changed {
+			chart.MarkNotCreated()
+		}
+	}
+}
+
+func setChartLabel(chart *module.Chart, lbl module.Label) bool {
+	for i, l := range chart.Labels {
+		if l.Key == lbl.Key {
+			if l.Value == lbl.Value {
+				return false
+			}
+			chart.Labels[i].Value = lbl.Value
+			return true
+		}
+	}
+	chart.Labels = append(chart.Labels, lbl)
+	return true
+}
+
+// reIDUnsafe matches the characters not allowed in the dynamic charts and dimensions IDs: netdata addresses a chart
+// as 'type.id', a dot in the ID breaks it.
+var reIDUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+func sanitizeID(s string) string {
+	return reIDUnsafe.ReplaceAllString(s, "_")
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// checkErrorClass is the cause of a failed Check(), it tells "ccache is not installed" from "ccache is installed but
+// the agent lacks permission" at a glance.
+type checkErrorClass string
+
+const (
+	checkErrorBinaryMissing     checkErrorClass = "binary missing"
+	checkErrorPermissionDenied  checkErrorClass = "permission denied"
+	checkErrorTimeout           checkErrorClass = "timeout"
+	checkErrorUnparseableOutput checkErrorClass = "unparseable output"
+	checkErrorNoActivity        checkErrorClass = "no activity"
+	checkErrorUnknown           checkErrorClass = "unknown"
+)
+
+var (
+	// errUnparseableOutput is returned if the stats output has no recognized stats (or is malformed).
+	errUnparseableOutput = errors.New("unparseable stats output")
+	// errNoActivity is returned if the cache has no stats yet (it has never been used).
+	errNoActivity = errors.New("no cache activity")
+)
+
+// classifyCollectError returns the class of the collection error.
+func classifyCollectError(err error) checkErrorClass {
+	var ee *execError
+	isExec := errors.As(err, &ee)
+
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return checkErrorUnknown
+	case isExec && ee.timedOut, errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return checkErrorTimeout
+	case errors.Is(err, fs.ErrPermission), isExec && strings.Contains(strings.ToLower(ee.stderr), "permission denied"):
+		// the binary is not executable, or ccache can't access the cache directory (or its config)
+		return checkErrorPermissionDenied
+	case errors.Is(err, exec.ErrNotFound), isExec && errors.Is(err, fs.ErrNotExist):
+		return checkErrorBinaryMissing
+	case errors.Is(err, errUnparseableOutput):
+		return checkErrorUnparseableOutput
+	case errors.Is(err, errNoActivity):
+		return checkErrorNoActivity
+	default:
+		return checkErrorUnknown
+	}
+}
+
+// checkErrorHint returns what the operator can do about the failed check.
+func (c *Ccache) checkErrorHint(class checkErrorClass) string {
+	switch class {
+	case checkErrorBinaryMissing:
+		return c.toolName() + " is not installed or not in the agent PATH, set 'binary_path'"
+	case checkErrorPermissionDenied:
+		return "the agent user lacks permission to run " + c.toolName() + " or to access the cache directory"
+	case checkErrorTimeout:
+		return "the stats query didn't complete in time, increase 'timeout'"
+	case checkErrorUnparseableOutput:
+		return "the stats output can't be parsed, the " + c.toolName() + " version may be unsupported"
+	case checkErrorNoActivity:
+		return "the cache has no stats yet, it has not been used"
+	default:
+		return "see the collection error"
+	}
+}
@@ -0,0 +1,839 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/netdata/go.d.plugin/agent/module"
+)
+
+const precision = 1000 // float values multiplier and dimensions divisor
+
+type collectionMode string
+
+const (
+	collectionModeExec  collectionMode = "exec"
+	collectionModeFile  collectionMode = "file"
+	collectionModeNodes collectionMode = "nodes"
+	collectionModeURL   collectionMode = "url"
+	collectionModeDirs  collectionMode = "dirs"
+	collectionModeFIFO  collectionMode = "fifo"
+	// collectionModeSources tries the configured 'sources' in order until one yields stats.
+	collectionModeSources collectionMode = "sources"
+)
+
+// hitRateBasis is the hit/miss percentages denominator.
+type hitRateBasis string
+
+const (
+	// hitRateBasisHitsMisses is the cacheable calls (hits and misses).
+	hitRateBasisHitsMisses hitRateBasis = "hits_misses"
+	// hitRateBasisAllCalls is all the calls, the uncacheable calls included.
+	hitRateBasisAllCalls hitRateBasis = "all_calls"
+)
+
+func (c *Ccache) hitRateBasis() hitRateBasis {
+	if c.HitRateBasis == "" {
+		return hitRateBasisHitsMisses
+	}
+	return hitRateBasis(c.HitRateBasis)
+}
+
+type statsFormat string
+
+const (
+	statsFormatJSON   statsFormat = "json"
+	statsFormatText   statsFormat = "text"
+	statsFormatLegacy statsFormat = "legacy"
+)
+
+// Stats keys as reported by 'ccache --print-stats' (ccache 4.x naming), grouped the same way
+// 'ccache --show-stats --verbose' groups them.
+var (
+	uncacheableCallsStats = []string{
+		"autoconf_test",
+		"bad_compiler_arguments",
+		"called_for_link",
+		"called_for_preprocessing",
+		"could_not_use_modules",
+		"could_not_use_precompiled_header",
+		"disabled",
+		"modified_input_file",
+		"multiple_source_files",
+		"no_input_file",
+		"output_to_stdout",
+		"recache",
+		"unsupported_code_directive",
+		"unsupported_compiler_option",
+		"unsupported_environment_variable",
+		"unsupported_source_language",
+	}
+	errorsStats = []string{
+		"bad_input_file",
+		"bad_output_file",
+		"compile_failed",
+		"compiler_check_failed",
+		"compiler_produced_empty_output",
+		"compiler_produced_no_output",
+		"compiler_produced_stdout",
+		"could_not_find_compiler",
+		"error_hashing_extra_file",
+		"internal_error",
+		"missing_cache_file",
+		"preprocessor_error",
+	}
+)
+
+func (c *Ccache) collect() (map[string]int64, error) {
+	c.checkVersion()
+
+	stats, err := c.getStats()
+	if err != nil {
+		return nil, err
+	}
+	if len(stats) == 0 {
+		return nil, fmt.Errorf("%w: no stats found in ccache output", errUnparseableOutput)
+	}
+
+	mx := make(map[string]int64)
+
+	c.addKeyedCharts(stats)
+	c.collectCacheStats(mx, stats)
+	c.collectMissesByMode(mx, stats)
+	c.collectCallsStats(mx, stats)
+	c.collectStorageStats(mx, stats)
+	c.collectDerivedMetrics(mx, stats)
+	// metrics_age_seconds is how long the previous stats have been reused ('skip_if_idle'), 0 if they are fresh.
+	mx["metrics_age_seconds"] = int64(time.Since(c.statsTime).Seconds())
+	c.collectMirrorAge(mx)
+	c.collectSloppiness(mx)
+	if c.ExpectedVersion != "" {
+		mx["version_matches_expected"] = boolToInt(c.versionMatchesExpected)
+	}
+	if c.SinceStart {
+		c.collectSinceStartStats(mx, stats)
+	}
+	if c.ShardBalance {
+		c.collectShardBalance(mx)
+	}
+	if c.FileCountCheck {
+		c.collectFileCountDiscrepancy(mx, stats)
+	}
+	c.collectStatLatency(mx, stats)
+	if c.PassthroughAllKeys {
+		c.collectRawStats(mx, stats)
+	}
+	c.collectUnknownStats(mx, stats)
+	if c.NodeBreakdown {
+		c.collectNodesStats(mx)
+	}
+	if collectionMode(c.CollectionMode) == collectionModeDirs {
+		c.collectCacheDirsStats(mx)
+	}
+	if collectionMode(c.CollectionMode) == collectionModeFile {
+		mx["stats_files_scanned"] = c.statsFilesScan.scanned
+		mx["stats_files_read_errors"] = c.statsFilesScan.readErrors
+	}
+
+	c.clampPercentages(mx)
+	c.fixCounterResets(mx, stats)
+	mx["cache_resets"] = c.cacheResets
+
+	c.prevStats = stats
+	if c.StateFile != "" {
+		c.saveState()
+	}
+	c.updateChartsLabels()
+
+	return mx, nil
+}
+
+// unsupportedOptionsStats are the uncacheable calls due to the compiler flags and source code constructs ccache can't
+// handle, they are charted apart to tell how much of the misses come from them. A spike after a flags change is
+// the actionable signal.
+var unsupportedOptionsStats = []string{
+	"unsupported_compiler_option",
+	"unsupported_code_directive",
+}
+
+// keyedCharts are the charts applicable only if the stats source reports (any of) their keys.
+var keyedCharts = []struct {
+	keys []string
+	add  func(c *Ccache)
+}{
+	{keys: []string{"direct_cache_miss", "preprocessed_cache_miss"}, add: (*Ccache).addMissesByModeCharts},
+	{keys: []string{"local_storage_hit"}, add: (*Ccache).addLocalStorageCharts},
+	{keys: []string{"remote_storage_hit"}, add: (*Ccache).addRemoteStorageCharts},
+	{keys: []string{"local_storage_write"}, add: (*Ccache).addEvictionPressureCharts},
+}
+
+// addKeyedCharts registers the applicable keyed charts in one pass, so the first successful collection creates
+// all of them at once. The subsequent collections add only the charts whose keys appear later (e.g. ccache upgrade).
+// The time since the last cleanup chart is not keyed, it has no value until a cleanup is observed.
+func (c *Ccache) addKeyedCharts(stats map[string]int64) {
+	for _, kc := range keyedCharts {
+		marker := kc.keys[0]
+		if c.collectedStats[marker] || !hasAnyKey(stats, kc.keys...) {
+			continue
+		}
+		c.collectedStats[marker] = true
+		kc.add(c)
+	}
+}
+
+func hasAnyKey(stats map[string]int64, keys ...string) bool {
+	for _, key := range keys {
+		if _, ok := stats[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// clampPercentages keeps the values of the percentage charts dimensions within 0-100. Out of range values come from
+// accounting anomalies (e.g. a remote source counting the operations differently than the timeouts), they are clamped
+// so the charts never show impossible values.
+func (c *Ccache) clampPercentages(mx map[string]int64) {
+	for _, chart := range *c.Charts() {
+		if chart.Units != "percentage" {
+			continue
+		}
+		for _, dim := range chart.Dims {
+			v, ok := mx[dim.ID]
+			if !ok {
+				continue
+			}
+			if clamped := clampPercentage(v); clamped != v {
+				c.Debugf("clamped '%s' %d to %d, the stats counters are inconsistent", dim.ID, v, clamped)
+				mx[dim.ID] = clamped
+			}
+		}
+	}
+}
+
+// clampPercentage clamps a percentage (multiplied by precision) to 0-100.
+func clampPercentage(v int64) int64 {
+	return min(max(v, 0), 100*precision)
+}
+
+// fixCounterResets keeps the values of incremental dimensions monotonic if ccache counters decrease.
+// On a stats reset ('ccache -z', stats files removed) the value before the drop becomes an offset, so the incremental
+// charts don't render a huge negative spike. Any other drop is a partial reading (a failed node, a skipped stats file,
+// an unreadable cache dir, a source fallback): the decreased counters keep their previous values until they catch up,
+// nothing is added to the offsets.
+func (c *Ccache) fixCounterResets(mx, stats map[string]int64) {
+	seen := make(map[string]bool)
+	var counters []string
+	var prevSum, sum int64
+
+	for _, chart := range *c.Charts() {
+		for _, dim := range chart.Dims {
+			if dim.Algo != module.Incremental || seen[dim.ID] {
+				continue
+			}
+			seen[dim.ID] = true
+
+			v, ok := mx[dim.ID]
+			if !ok {
+				continue
+			}
+			counters = append(counters, dim.ID)
+			if prev, ok := c.prevCounters[dim.ID]; ok && v < prev {
+				prevSum += prev
+				sum += v
+			}
+		}
+	}
+
+	reset := prevSum > 0 && c.isStatsReset(stats, prevSum, sum)
+
+	for _, id := range counters {
+		v := mx[id]
+		if prev, ok := c.prevCounters[id]; ok && v < prev {
+			if !reset {
+				c.Debugf("counter '%s' decreased (%d => %d) without a stats reset, keeping the previous value", id, prev, v)
+				mx[id] = prev + c.counterOffsets[id]
+				continue
+			}
+			c.Debugf("counter '%s' reset (%d => %d), resetting its baseline", id, prev, v)
+			c.counterOffsets[id] += prev
+		}
+		c.prevCounters[id] = v
+		mx[id] = v + c.counterOffsets[id]
+	}
+
+	// a reset zeroes all the counters at once, it is counted once
+	if reset {
+		c.cacheResets++
+	}
+}
+
+// counterResetMaxRemaining is the share (1/N) of their previous values the decreased counters may keep for the drop
+// to be a stats reset.
+const counterResetMaxRemaining = 10
+
+// isStatsReset tells a stats reset from a partial reading. The stats zeroed timestamp decides if both the current and
+// the previous stats have it (it only goes forward on a reset, a sum of the nodes timestamps goes back if a node
+// fails), otherwise the decreased counters must be back near zero.
+func (c *Ccache) isStatsReset(stats map[string]int64, prevSum, sum int64) bool {
+	ts, ok := stats["stats_zeroed_timestamp"]
+	prevTS, prevOK := c.prevStats["stats_zeroed_timestamp"]
+	if ok && prevOK {
+		return ts > prevTS
+	}
+	return sum*counterResetMaxRemaining <= prevSum
+}
+
+func (c *Ccache) getStats() (map[string]int64, error) {
+	if c.SkipIfIdle && c.isCacheIdle() && c.prevStats != nil {
+		c.Debugf("cache '%s' has not been used since the last collection, reusing previous stats", c.cacheDir)
+		return c.prevStats, nil
+	}
+
+	stats, err := c.queryModeStats()
+	if err != nil {
+		return nil, err
+	}
+	// every source's stats are normalized here: the legacy, text and json parsers, the stats files and the nodes
+	normalizeStatsKeys(stats)
+	if c.SelfTest {
+		c.selfTest(stats)
+	}
+	c.statsTime = time.Now()
+
+	return stats, nil
+}
+
+func (c *Ccache) queryModeStats() (map[string]int64, error) {
+	switch collectionMode(c.CollectionMode) {
+	case collectionModeFile:
+		return c.queryStatsFiles()
+	case collectionModeNodes:
+		return c.queryNodesStats()
+	case collectionModeURL:
+		return c.queryNode(c.urlNode)
+	case collectionModeDirs:
+		return c.queryCacheDirsStats()
+	case collectionModeFIFO:
+		return c.queryFIFO()
+	case collectionModeSources:
+		return c.querySources()
+	default:
+		return c.queryStats()
+	}
+}
+
+// isCacheIdle reports whether none of the cache stats files has been modified since the previous check.
+func (c *Ccache) isCacheIdle() bool {
+	mtime, err := lastStatsModTime(c.cacheDir)
+	if err != nil {
+		c.Debugf("check cache '%s' stats files modification time: %v", c.cacheDir, err)
+		return false
+	}
+
+	idle := !c.statsModTime.IsZero() && !mtime.After(c.statsModTime)
+	c.statsModTime = mtime
+
+	return idle
+}
+
+func (c *Ccache) queryStats() (map[string]int64, error) {
+	if c.exec == nil {
+		return nil, errors.New("ccache exec is not initialized")
+	}
+
+	bs, parse, err := c.execStats(c.statsFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	c.debugRawOutput(bs)
+
+	stats, err := c.parseExecStats(c.statsFormat, bs, parse)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnparseableOutput, err)
+	}
+	return stats, nil
+}
+
+// parseExecStats parses the stats command output, for the '--show-stats' output it records the stats sections
+// so the stats are grouped into charts by them.
+func (c *Ccache) parseExecStats(format statsFormat, bs []byte, parse func([]byte) (map[string]int64, error)) (map[string]int64, error) {
+	if format != statsFormatLegacy {
+		c.statsSections = nil
+		return parse(bs)
+	}
+
+	stats, sections, err := parseShowStats(bs)
+	c.statsSections = sections
+	return stats, err
+}
+
+// execStats runs the stats command of the given format, it returns the raw output and the matching parser.
+func (c *Ccache) execStats(format statsFormat) ([]byte, func([]byte) (map[string]int64, error), error) {
+	return execStatsCommand(c.exec, format, c.showStatsFlag)
+}
+
+// execStatsCommand runs the stats command of the format, the legacy format runs showStatsFlag.
+func execStatsCommand(cli ccacheCLI, format statsFormat, showStatsFlag string) ([]byte, func([]byte) (map[string]int64, error), error) {
+	var bs []byte
+	var err error
+	var parse func([]byte) (map[string]int64, error)
+
+	switch format {
+	case statsFormatJSON:
+		bs, err = cli.printStatsJSON()
+		parse = parseStatsJSON
+	case statsFormatText:
+		bs, err = cli.printStats()
+		parse = parseStatsText
+	case statsFormatLegacy:
+		bs, err = cli.showStats(showStatsFlag)
+		parse = parseStatsLegacy
+	case statsFormatSccache:
+		bs, err = cli.sccacheStats()
+		parse = parseStatsSccache
+	default:
+		return nil, nil, fmt.Errorf("unknown stats format '%s'", format)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("exec ccache stats ('%s' format): %w", format, err)
+	}
+	if bs, err = maybeDecompress(bs); err != nil {
+		return nil, nil, fmt.Errorf("exec ccache stats ('%s' format): %w", format, err)
+	}
+
+	return bs, parse, nil
+}
+
+const rawOutputMaxLen = 16 * 1024
+
+var reHomeDir = regexp.MustCompile(`(/home/|/Users/|\\Users\\)[^/\\\s]+`)
+
+// debugRawOutput logs the raw stats output at most once per rawOutputLogEvery.
+// User names in home directory paths are redacted.
+func (c *Ccache) debugRawOutput(bs []byte) {
+	if !c.DebugRawOutput {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(c.rawOutputLogTime) < c.rawOutputLogEvery {
+		return
+	}
+	c.rawOutputLogTime = now
+
+	if len(bs) > rawOutputMaxLen {
+		bs = bs[:rawOutputMaxLen]
+	}
+
+	c.Debugf("raw '%s' stats output:\n%s", c.statsFormat, redactHomeDirs(bs))
+}
+
+func redactHomeDirs(bs []byte) []byte {
+	return reHomeDir.ReplaceAll(bs, []byte("${1}***"))
+}
+
+func (c *Ccache) collectCacheStats(mx map[string]int64, stats map[string]int64) {
+	calls := newCallsStats(stats)
+
+	mx["direct_cache_hit"] = stats["direct_cache_hit"]
+	mx["preprocessed_cache_hit"] = stats["preprocessed_cache_hit"]
+	mx["cache_miss"] = stats["cache_miss"]
+	mx["total_calls"] = calls.total()
+
+	mx["cache_size"] = c.cacheSizeBytes(stats)
+	mx["files_in_cache"] = stats["files_in_cache"]
+	mx["cleanups_performed"] = stats["cleanups_performed"]
+}
+
+// cacheSizeKeys are the cache size stats keys (and their multiplier to bytes) ccache versions/sources report.
+var cacheSizeKeys = []struct {
+	key string
+	mul int64
+}{
+	{key: "cache_size_kibibyte", mul: 1024},
+	{key: "cache_size_bytes", mul: 1},
+	{key: "cache_size", mul: 1},
+}
+
+// cacheSizeBytes returns the cache size in bytes. The size key is probed once and reused by the subsequent collections,
+// it is probed again only if the recorded key disappears (e.g. after a ccache upgrade).
+func (c *Ccache) cacheSizeBytes(stats map[string]int64) int64 {
+	if _, ok := stats[c.cacheSizeKey]; !ok || c.cacheSizeKey == "" {
+		c.cacheSizeKey, c.cacheSizeMul = "", 0
+		for _, v := range cacheSizeKeys {
+			if _, ok := stats[v.key]; ok {
+				c.cacheSizeKey, c.cacheSizeMul = v.key, v.mul
+				c.Debugf("cache size key: '%s' (multiplier %d)", v.key, v.mul)
+				break
+			}
+		}
+		if c.cacheSizeKey == "" {
+			return 0
+		}
+	}
+
+	return stats[c.cacheSizeKey] * c.cacheSizeMul
+}
+
+// collectMissesByMode reports direct and preprocessed mode misses. Older ccache versions don't split misses by mode,
+// the chart is added only once either of the keys is reported.
+func (c *Ccache) collectMissesByMode(mx map[string]int64, stats map[string]int64) {
+	_, direct := stats["direct_cache_miss"]
+	_, preprocessed := stats["preprocessed_cache_miss"]
+	if !direct && !preprocessed {
+		return
+	}
+
+	mx["direct_cache_miss"] = stats["direct_cache_miss"]
+	mx["preprocessed_cache_miss"] = stats["preprocessed_cache_miss"]
+}
+
+func (c *Ccache) collectCallsStats(mx map[string]int64, stats map[string]int64) {
+	for _, key := range c.sectionStatsKeys(sectionUncacheable, uncacheableCallsStats) {
+		v, ok := stats[key]
+		if !ok {
+			continue
+		}
+		mx[key] = v
+		if !c.collectedStats[key] {
+			c.collectedStats[key] = true
+			c.addDimToChart(&uncacheableCallsChart, &module.Dim{ID: key, Name: key, Algo: module.Incremental})
+		}
+	}
+
+	// the unsupported options share the uncacheable calls keys, the chart ID is the marker (not keyed)
+	if hasAnyKey(stats, unsupportedOptionsStats...) {
+		for _, key := range unsupportedOptionsStats {
+			mx[key] = stats[key]
+		}
+		if !c.collectedStats[unsupportedOptionsChart.ID] {
+			c.collectedStats[unsupportedOptionsChart.ID] = true
+			c.addUnsupportedOptionsCharts()
+		}
+	}
+
+	for _, key := range c.sectionStatsKeys(sectionErrors, errorsStats) {
+		v, ok := stats[key]
+		if !ok {
+			continue
+		}
+		mx[key] = v
+		if !c.collectedStats[key] {
+			c.collectedStats[key] = true
+			c.addDimToChart(&errorsChart, &module.Dim{ID: key, Name: key, Algo: module.Incremental})
+		}
+	}
+}
+
+func (c *Ccache) collectStorageStats(mx map[string]int64, stats map[string]int64) {
+	if _, ok := stats["local_storage_hit"]; ok {
+		mx["local_storage_hit"] = stats["local_storage_hit"]
+		mx["local_storage_miss"] = stats["local_storage_miss"]
+	}
+
+	if _, ok := stats["remote_storage_hit"]; ok {
+		mx["remote_storage_hit"] = stats["remote_storage_hit"]
+		mx["remote_storage_miss"] = stats["remote_storage_miss"]
+		mx["remote_storage_error"] = stats["remote_storage_error"]
+		mx["remote_storage_timeout"] = stats["remote_storage_timeout"]
+	}
+}
+
+// collectIOSaved reports an estimate of the disk I/O avoided by cache hits: the hits of every interval times
+// the average cached object size. It is an approximation, the hit objects are not necessarily of the average size.
+// The estimate is accumulated, so it doesn't decrease when the average object size does.
+func (c *Ccache) collectIOSaved(mx map[string]int64, stats map[string]int64) {
+	if c.prevStats != nil {
+		hits := stats["direct_cache_hit"] + stats["preprocessed_cache_hit"]
+		prevHits := c.prevStats["direct_cache_hit"] + c.prevStats["preprocessed_cache_hit"]
+		// avg_object_size_bytes is 0 if there are no files in the cache
+		c.estimatedIOSaved += max(0, hits-prevHits) * mx["avg_object_size_bytes"]
+	}
+	mx["estimated_io_saved_bytes"] = c.estimatedIOSaved
+}
+
+// collectCacheChurn reports the number of files added to and removed from the cache during the last interval.
+// The added files are the local storage writes if reported, the 'files_in_cache' growth otherwise; the removed files
+// are derived from the files count drop, they are counted only if cleanups were performed during the interval.
+// A drop without cleanups (cache cleared, stats zeroed) is not reported as churn.
+func (c *Ccache) collectCacheChurn(mx map[string]int64, stats map[string]int64) {
+	mx["files_added_per_interval"] = 0
+	mx["files_removed_per_interval"] = 0
+	if c.prevStats == nil {
+		return
+	}
+
+	delta := stats["files_in_cache"] - c.prevStats["files_in_cache"]
+	cleanups := stats["cleanups_performed"] - c.prevStats["cleanups_performed"]
+	if cleanups < 0 {
+		return
+	}
+
+	added := max(0, delta)
+	if _, ok := stats["local_storage_write"]; ok {
+		writes := stats["local_storage_write"] - c.prevStats["local_storage_write"]
+		if writes < 0 {
+			return
+		}
+		added = writes
+	}
+
+	var removed int64
+	if cleanups > 0 {
+		removed = max(0, added-delta)
+	}
+
+	mx["files_added_per_interval"] = added
+	mx["files_removed_per_interval"] = removed
+}
+
+// sectionStatsKeys returns the stats keys of the section as grouped by the last '--show-stats' output (ccache 4.x),
+// or the predefined keys if the output had no sections.
+func (c *Ccache) sectionStatsKeys(section statsSection, keys []string) []string {
+	if len(c.statsSections) == 0 {
+		return keys
+	}
+
+	var sectionKeys []string
+	for key, s := range c.statsSections {
+		if s == section {
+			sectionKeys = append(sectionKeys, key)
+		}
+	}
+	sort.Strings(sectionKeys)
+
+	return sectionKeys
+}
+
+// collectLastCleanup reports the time since the last cache cleanup. ccache doesn't record when cleanups happen,
+// so it is the time since 'cleanups_performed' was last seen increasing; nothing is reported until then.
+func (c *Ccache) collectLastCleanup(mx map[string]int64, stats map[string]int64) {
+	if c.prevStats != nil && stats["cleanups_performed"] > c.prevStats["cleanups_performed"] {
+		if c.lastCleanupTime.IsZero() {
+			c.addLastCleanupCharts()
+		}
+		c.lastCleanupTime = time.Now()
+	}
+
+	if !c.lastCleanupTime.IsZero() {
+		mx["seconds_since_last_cleanup"] = int64(time.Since(c.lastCleanupTime).Seconds())
+	}
+}
+
+// collectRecentStats reports the hit ratio and the uncacheable calls of the last collection interval.
+// Values are deltas against the previous collection, so the first collection reports zeros.
+func (c *Ccache) collectRecentStats(mx map[string]int64, stats map[string]int64) {
+	delta := func(key string) int64 {
+		if c.prevStats == nil {
+			return 0
+		}
+		return max(0, stats[key]-c.prevStats[key])
+	}
+
+	var calls callsStats
+	if c.prevStats != nil {
+		calls = newCallsStats(stats).sub(newCallsStats(c.prevStats))
+	}
+	calls.writePercentages(mx, "recent_", c.hitRateBasis())
+	mx["hit_rate_trend"] = c.hitRateTrend(calls)
+
+	for _, key := range uncacheableCallsStats {
+		if _, ok := stats[key]; !ok {
+			continue
+		}
+		id := "recent_" + key
+		mx[id] = delta(key)
+		if !c.collectedStats[id] {
+			c.collectedStats[id] = true
+			c.addDimToChart(&recentMissReasonsChart, &module.Dim{ID: id, Name: key})
+		}
+	}
+}
+
+// defaultEffectiveHitRateThreshold is the default 'effective_hit_rate_threshold', in percent.
+const defaultEffectiveHitRateThreshold = 50
+
+// collectCacheEffective reports a single red/green cache health signal: 1 if the cache was used during the last
+// collection interval (cache hits or misses) and the recent hit ratio exceeds 'effective_hit_rate_threshold', else 0.
+// It is reported every collection (0 on the first one, there is no interval yet), so it can be alerted on.
+func (c *Ccache) collectCacheEffective(mx map[string]int64, stats map[string]int64) {
+	var calls int64
+	if c.prevStats != nil {
+		for _, key := range []string{"direct_cache_hit", "preprocessed_cache_hit", "cache_miss"} {
+			calls += max(0, stats[key]-c.prevStats[key])
+		}
+	}
+
+	active := calls > 0
+	effective := float64(mx["recent_cache_hit_percentage"]) > c.EffectiveHitRateThreshold*precision
+	mx["cache_effective"] = boolToInt(active && effective)
+}
+
+func boolToInt(v bool) int64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// collectEvictionPressure reports the cleanups per cache write during the last collection interval.
+// A cache that cleans up almost as often as it writes is thrashing (too small for the working set).
+// The writes are the local storage writes (ccache 4.x), nothing is reported if ccache doesn't count them.
+func (c *Ccache) collectEvictionPressure(mx map[string]int64, stats map[string]int64) {
+	if _, ok := stats["local_storage_write"]; !ok {
+		return
+	}
+
+	mx["cleanup_per_write_ratio"] = 0
+	if c.prevStats != nil {
+		cleanups := max(0, stats["cleanups_performed"]-c.prevStats["cleanups_performed"])
+		writes := max(0, stats["local_storage_write"]-c.prevStats["local_storage_write"])
+		if writes > 0 {
+			mx["cleanup_per_write_ratio"] = cleanups * precision / writes
+		}
+	}
+
+}
+
+// hitRateTrendDeadBand is the hit ratio change (percentage points * precision) below which the trend is flat.
+const hitRateTrendDeadBand = 1 * precision
+
+// hitRateTrend compares the hit ratio of the last collection interval with the previous interval's:
+// 1 if it went up, -1 if it went down, 0 if it changed less than hitRateTrendDeadBand.
+// Intervals without calls have no hit ratio, they are reported as flat and don't replace the previous sample.
+func (c *Ccache) hitRateTrend(calls callsStats) int64 {
+	total := calls.basisTotal(c.hitRateBasis())
+	if total == 0 {
+		return 0
+	}
+
+	ratio := calls.hits * precision * 100 / total
+	prev, ok := c.prevRecentHitRatio, c.hasPrevRecentHitRatio
+	c.prevRecentHitRatio, c.hasPrevRecentHitRatio = ratio, true
+	if !ok {
+		return 0
+	}
+
+	switch diff := ratio - prev; {
+	case diff > hitRateTrendDeadBand:
+		return 1
+	case diff < -hitRateTrendDeadBand:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// collectSinceStartStats reports the calls since the job started: counters are relative to the snapshot
+// taken on the first collection. The snapshot is retaken if the counters go backwards (zeroed stats).
+func (c *Ccache) collectSinceStartStats(mx map[string]int64, stats map[string]int64) {
+	calls := func(s map[string]int64) int64 { return newCallsStats(s).total() }
+
+	switch {
+	case c.baseStats == nil:
+		c.Infof("since start: taking the counters baseline (%d calls)", calls(stats))
+		c.baseStats = stats
+	case calls(stats) < calls(c.baseStats):
+		c.Warningf("since start: counters went backwards (%d => %d calls), the stats were zeroed, re-baselining",
+			calls(c.baseStats), calls(stats))
+		c.baseStats = stats
+	}
+
+	delta := func(key string) int64 { return max(0, stats[key]-c.baseStats[key]) }
+
+	direct, preprocessed, misses := delta("direct_cache_hit"), delta("preprocessed_cache_hit"), delta("cache_miss")
+
+	mx["since_start_direct_cache_hit"] = direct
+	mx["since_start_preprocessed_cache_hit"] = preprocessed
+	mx["since_start_cache_miss"] = misses
+
+	newCallsStats(stats).sub(newCallsStats(c.baseStats)).writePercentages(mx, "since_start_", c.hitRateBasis())
+}
+
+// callsStats groups the ccache calls, the total of all calls is the denominator of every calls percentage.
+type callsStats struct {
+	hits        int64
+	misses      int64
+	uncacheable int64
+}
+
+func newCallsStats(stats map[string]int64) callsStats {
+	s := callsStats{
+		hits:   stats["direct_cache_hit"] + stats["preprocessed_cache_hit"],
+		misses: stats["cache_miss"],
+	}
+	for _, key := range uncacheableCallsStats {
+		s.uncacheable += stats[key]
+	}
+	return s
+}
+
+func (s callsStats) total() int64 {
+	return s.hits + s.misses + s.uncacheable
+}
+
+// basisTotal is the hit/miss percentages denominator.
+func (s callsStats) basisTotal(basis hitRateBasis) int64 {
+	if basis == hitRateBasisAllCalls {
+		return s.total()
+	}
+	return s.hits + s.misses
+}
+
+func (s callsStats) sub(prev callsStats) callsStats {
+	return callsStats{
+		hits:        max(0, s.hits-prev.hits),
+		misses:      max(0, s.misses-prev.misses),
+		uncacheable: max(0, s.uncacheable-prev.uncacheable),
+	}
+}
+
+// writePercentages writes the hit/miss percentages of the basis, and the uncacheable percentage with the 'all_calls' basis
+// (the uncacheable calls are not part of the 'hits_misses' basis).
+func (s callsStats) writePercentages(mx map[string]int64, prefix string, basis hitRateBasis) {
+	total := s.basisTotal(basis)
+
+	mx[prefix+"cache_hit_percentage"] = 0
+	mx[prefix+"cache_miss_percentage"] = 0
+	if total > 0 {
+		mx[prefix+"cache_hit_percentage"] = s.hits * precision * 100 / total
+		mx[prefix+"cache_miss_percentage"] = s.misses * precision * 100 / total
+	}
+	if basis == hitRateBasisAllCalls {
+		mx[prefix+"cache_uncacheable_percentage"] = 0
+		if total > 0 {
+			mx[prefix+"cache_uncacheable_percentage"] = s.uncacheable * precision * 100 / total
+		}
+	}
+}
+
+// collectRawStats reports every stats key as is, the keys set depends on the ccache version and is not stable.
+func (c *Ccache) collectRawStats(mx map[string]int64, stats map[string]int64) {
+	for key, v := range stats {
+		id := "raw_" + key
+		mx[id] = v
+		if !c.collectedStats[id] {
+			c.collectedStats[id] = true
+			c.addDimToChart(&rawStatsChart, &module.Dim{ID: id, Name: key})
+		}
+	}
+}
+
+func (c *Ccache) collectNodesStats(mx map[string]int64) {
+	for _, node := range c.nodes {
+		stats, ok := c.nodesStats[node.name]
+		if !ok {
+			continue
+		}
+		px := "node_" + node.id + "_"
+		mx[px+"cache_hit"] = stats["direct_cache_hit"] + stats["preprocessed_cache_hit"]
+		mx[px+"cache_miss"] = stats["cache_miss"]
+	}
+}
@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statsFileCounters are the counters of a ccache stats file, a counter per line in this order
+// (ccache 'Statistic' enum). Empty names are obsolete or internal counters.
+var statsFileCounters = []string{
+	"",
+	"compiler_produced_stdout",
+	"compile_failed",
+	"internal_error",
+	"cache_miss",
+	"preprocessor_error",
+	"could_not_find_compiler",
+	"missing_cache_file",
+	"preprocessed_cache_hit",
+	"bad_compiler_arguments",
+	"called_for_link",
+	"files_in_cache",
+	"cache_size_kibibyte",
+	"",
+	"",
+	"unsupported_source_language",
+	"bad_output_file",
+	"no_input_file",
+	"multiple_source_files",
+	"autoconf_test",
+	"unsupported_compiler_option",
+	"output_to_stdout",
+	"direct_cache_hit",
+	"compiler_produced_no_output",
+	"compiler_produced_empty_output",
+	"error_hashing_extra_file",
+	"compiler_check_failed",
+	"could_not_use_precompiled_header",
+	"called_for_preprocessing",
+	"cleanups_performed",
+	"unsupported_code_directive",
+	"",
+	"could_not_use_modules",
+	"direct_cache_miss",
+	"preprocessed_cache_miss",
+	"local_storage_hit",
+	"local_storage_miss",
+	"remote_storage_hit",
+	"remote_storage_miss",
+	"remote_storage_error",
+	"remote_storage_timeout",
+	"recache",
+	"unsupported_environment_variable",
+	"modified_input_file",
+}
+
+// queryStatsFiles reads and sums the cache stats files, without executing ccache.
+// The reads are done in a separate goroutine so a slow (e.g. NFS mounted) cache dir can't block the collection
+// longer than 'timeout', and Cleanup() aborts it.
+func (c *Ccache) queryStatsFiles() (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, c.Timeout.Duration)
+	defer cancel()
+
+	type result struct {
+		stats map[string]int64
+		scan  statsFilesScan
+		err   error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		stats, scan, err := c.readStatsFiles(ctx, c.cacheDir)
+		ch <- result{stats: stats, scan: scan, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("read '%s' stats files: %v", c.cacheDir, ctx.Err())
+	case res := <-ch:
+		if res.err == nil {
+			c.statsFilesScan = res.scan
+		}
+		return res.stats, res.err
+	}
+}
+
+// statsFilesScan summarizes a stats files read: a drop in the scanned files (e.g. after a permissions change)
+// means the collector doesn't see the whole cache, unlike a drop in the cache activity.
+type statsFilesScan struct {
+	// scanned is the number of stats files read and summed.
+	scanned int64
+	// readErrors is the number of stats files that couldn't be read, decompressed or parsed.
+	readErrors int64
+}
+
+func (c *Ccache) readStatsFiles(ctx context.Context, cacheDir string) (map[string]int64, statsFilesScan, error) {
+	var scan statsFilesScan
+
+	files, err := findStatsFiles(cacheDir)
+	if err != nil {
+		return nil, scan, err
+	}
+	if len(files) == 0 {
+		return nil, scan, fmt.Errorf("%w: no stats files found in '%s'", errNoActivity, cacheDir)
+	}
+
+	stats := make(map[string]int64)
+
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return nil, scan, ctx.Err()
+		}
+
+		fileStats, err := c.readStatsFile(file)
+		if err != nil {
+			c.Debugf("read stats file '%s': %v", file, err)
+			scan.readErrors++
+			continue
+		}
+		for k, v := range fileStats {
+			stats[k] += v
+		}
+		scan.scanned++
+	}
+
+	return stats, scan, nil
+}
+
+var (
+	// errTornStatsFile is a stats file read while it was being written: empty or cut before the final newline.
+	errTornStatsFile = errors.New("truncated stats file (concurrent write)")
+	// errLockedStatsFile is a stats file the writer holds locked (e.g. a sharing violation on Windows).
+	errLockedStatsFile = errors.New("locked stats file (concurrent write)")
+)
+
+// statsFileRetryDelay is how long a torn stats file read waits before the retry.
+const statsFileRetryDelay = 10 * time.Millisecond
+
+// readStatsFile reads and parses a stats file, tolerating concurrent writers (a build updating the stats): a torn read
+// (or a locked file) is retried once, a file that still can't be read cleanly is skipped. The file is parsed into its own map, so a bad
+// file never contributes a part of its counters.
+func (c *Ccache) readStatsFile(file string) (map[string]int64, error) {
+	stats, err := c.readStatsFileOnce(file)
+	if errors.Is(err, errTornStatsFile) || errors.Is(err, errLockedStatsFile) {
+		time.Sleep(statsFileRetryDelay)
+		stats, err = c.readStatsFileOnce(file)
+	}
+	return stats, err
+}
+
+func (c *Ccache) readStatsFileOnce(file string) (map[string]int64, error) {
+	bs, err := c.readFile(file)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", errLockedStatsFile, err)
+	}
+	if bs, err = maybeDecompress(bs); err != nil {
+		// a compressed file cut mid-write doesn't decompress
+		return nil, fmt.Errorf("%w: %v", errTornStatsFile, err)
+	}
+	// ccache writes every counter followed by a newline
+	if len(bytes.TrimSpace(bs)) == 0 || !bytes.HasSuffix(bs, []byte("\n")) {
+		return nil, errTornStatsFile
+	}
+
+	stats := make(map[string]int64)
+	if err := parseStatsFile(bs, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func parseStatsFile(bs []byte, stats map[string]int64) error {
+	for i, field := range strings.Fields(string(bs)) {
+		if i >= len(statsFileCounters) {
+			break
+		}
+		key := statsFileCounters[i]
+		if key == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return errors.New("not a ccache stats file")
+		}
+		stats[key] += v
+	}
+	return nil
+}
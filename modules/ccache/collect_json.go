@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// parseStatsJSONOrText parses stats of a non-exec source, that provides either the json or the text format.
+func parseStatsJSONOrText(bs []byte) (map[string]int64, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(bs), []byte("{")) {
+		return parseStatsJSON(bs)
+	}
+	return parseStatsText(bs)
+}
+
+// parseStatsJSON parses 'ccache --print-stats --format=json' output: a flat object of stats keys to numbers.
+func parseStatsJSON(bs []byte) (map[string]int64, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(bs, &raw); err != nil {
+		return nil, fmt.Errorf("unmarshal stats json: %v", err)
+	}
+
+	stats := make(map[string]int64)
+
+	for k, v := range raw {
+		if n, ok := v.(float64); ok {
+			stats[k] = int64(n)
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,277 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Legacy (< 3.7) 'ccache --show-stats' labels mapped to the '--print-stats' keys.
+var legacyStatsKeys = map[string]string{
+	"autoconf compile/link":          "autoconf_test",
+	"bad compiler arguments":         "bad_compiler_arguments",
+	"cache file missing":             "missing_cache_file",
+	"cache hit (direct)":             "direct_cache_hit",
+	"cache hit (preprocessed)":       "preprocessed_cache_hit",
+	"cache miss":                     "cache_miss",
+	"called for link":                "called_for_link",
+	"called for preprocessing":       "called_for_preprocessing",
+	"ccache internal error":          "internal_error",
+	"cleanups performed":             "cleanups_performed",
+	"compile failed":                 "compile_failed",
+	"compiler check failed":          "compiler_check_failed",
+	"compiler produced empty output": "compiler_produced_empty_output",
+	"compiler produced no output":    "compiler_produced_no_output",
+	"compiler produced stdout":       "compiler_produced_stdout",
+	"couldn't find the compiler":     "could_not_find_compiler",
+	"could not write to output file": "bad_output_file",
+	"error hashing extra file":       "error_hashing_extra_file",
+	"files in cache":                 "files_in_cache",
+	"multiple source files":          "multiple_source_files",
+	"no input file":                  "no_input_file",
+	"output to stdout":               "output_to_stdout",
+	"preprocessor error":             "preprocessor_error",
+	"unsupported code directive":     "unsupported_code_directive",
+	"unsupported compiler option":    "unsupported_compiler_option",
+	"unsupported source language":    "unsupported_source_language",
+}
+
+var (
+	// label and value are separated by at least two spaces or a tab, e.g. "cache hit (direct)     4706"
+	reLegacyStatsLine = regexp.MustCompile(`^(\S+(?: \S+)*)(?:\s{2,}|\s*\t\s*)(\S.*)$`)
+	// ccache 4.x entries are "<label>: <value>", indented under the section header, e.g. "  Hits:   4891 / 6191 (79.00%)"
+	reShowStatsEntry = regexp.MustCompile(`^([ \t]*)(\S+(?: \S+)*):(?:\s+(\S.*))?$`)
+	reCacheSizeUnit  = regexp.MustCompile(`^Cache size \((\w+)\)$`)
+	reNonKeyChars    = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// statsSection is a ccache 4.x '--show-stats' output section, the stats are grouped into charts by it.
+type statsSection string
+
+const (
+	sectionCacheable     statsSection = "cacheable"
+	sectionUncacheable   statsSection = "uncacheable"
+	sectionErrors        statsSection = "errors"
+	sectionLocalStorage  statsSection = "local_storage"
+	sectionRemoteStorage statsSection = "remote_storage"
+)
+
+// showStatsSections are the ccache 4.x '--show-stats' section headers, before 4.4 the storages were named primary/secondary.
+var showStatsSections = map[string]statsSection{
+	"Cacheable calls":   sectionCacheable,
+	"Uncacheable calls": sectionUncacheable,
+	"Errors":            sectionErrors,
+	"Local storage":     sectionLocalStorage,
+	"Primary storage":   sectionLocalStorage,
+	"Remote storage":    sectionRemoteStorage,
+	"Secondary storage": sectionRemoteStorage,
+}
+
+// showStatsSectionKeys are the ccache 4.x '--show-stats' entries ("<parent>/<label>" for nested ones) mapped to the
+// '--print-stats' keys, per section.
+var showStatsSectionKeys = map[statsSection]map[string]string{
+	sectionCacheable: {
+		"Hits/Direct":       "direct_cache_hit",
+		"Hits/Preprocessed": "preprocessed_cache_hit",
+		"Misses":            "cache_miss",
+	},
+	sectionLocalStorage: {
+		"Files":    "files_in_cache",
+		"Cleanups": "cleanups_performed",
+		"Hits":     "local_storage_hit",
+		"Misses":   "local_storage_miss",
+		"Writes":   "local_storage_write",
+	},
+	sectionRemoteStorage: {
+		"Hits":     "remote_storage_hit",
+		"Misses":   "remote_storage_miss",
+		"Errors":   "remote_storage_error",
+		"Timeouts": "remote_storage_timeout",
+		"Writes":   "remote_storage_write",
+	},
+}
+
+// showStatsReasonKeys are the ccache 4.x uncacheable calls and errors labels mapped to the '--print-stats' keys.
+// Labels that are not listed (added in newer versions) get a key derived from the label.
+var showStatsReasonKeys = map[string]string{
+	"Autoconf compile/link":                  "autoconf_test",
+	"Bad compiler arguments":                 "bad_compiler_arguments",
+	"Called for linking":                     "called_for_link",
+	"Called for preprocessing":               "called_for_preprocessing",
+	"Ccache disabled":                        "disabled",
+	"Compilation failed":                     "compile_failed",
+	"Compiler check failed":                  "compiler_check_failed",
+	"Compiler output file missing":           "compiler_produced_empty_output",
+	"Compiler produced no output":            "compiler_produced_no_output",
+	"Compiler produced stdout":               "compiler_produced_stdout",
+	"Could not find compiler":                "could_not_find_compiler",
+	"Could not read or parse input file":     "bad_input_file",
+	"Could not use modules":                  "could_not_use_modules",
+	"Could not use precompiled header":       "could_not_use_precompiled_header",
+	"Could not write to output file":         "bad_output_file",
+	"Error hashing extra file":               "error_hashing_extra_file",
+	"Forced recache":                         "recache",
+	"Input file modified during compilation": "modified_input_file",
+	"Internal error":                         "internal_error",
+	"Missing cache file":                     "missing_cache_file",
+	"Multiple source files":                  "multiple_source_files",
+	"No input file":                          "no_input_file",
+	"Output to stdout":                       "output_to_stdout",
+	"Preprocessing failed":                   "preprocessor_error",
+	"Unsupported code directive":             "unsupported_code_directive",
+	"Unsupported compiler option":            "unsupported_compiler_option",
+	"Unsupported environment variable":       "unsupported_environment_variable",
+	"Unsupported source language":            "unsupported_source_language",
+}
+
+// parseStatsLegacy parses human-readable 'ccache --show-stats' output of ccache versions that lack '--print-stats'.
+func parseStatsLegacy(bs []byte) (map[string]int64, error) {
+	stats, _, err := parseShowStats(bs)
+	return stats, err
+}
+
+// parseShowStats parses human-readable 'ccache --show-stats' output, both the flat (< 4.0) and the sectioned
+// (4.x, entries indented under headers like "Cacheable calls:") layouts. For the latter it also returns the section
+// of every stat.
+func parseShowStats(bs []byte) (map[string]int64, map[string]statsSection, error) {
+	stats := make(map[string]int64)
+	sections := make(map[string]statsSection)
+
+	var section statsSection
+	var parent string
+	var parentIndent int
+
+	sc := bufio.NewScanner(bytes.NewReader(bs))
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+
+		if match := reShowStatsEntry.FindStringSubmatch(line); match != nil {
+			indent, label, value := len(match[1]), match[2], match[3]
+
+			var path string
+			switch {
+			case indent == 0:
+				section, parent, parentIndent = showStatsSections[label], "", 0
+				continue
+			case parentIndent == 0 || indent <= parentIndent:
+				parent, parentIndent = label, indent
+				path = label
+			default:
+				path = parent + "/" + label
+			}
+
+			if section == sectionLocalStorage {
+				if m := reCacheSizeUnit.FindStringSubmatch(label); m != nil {
+					if fields := strings.Fields(value); len(fields) > 0 {
+						if v, ok := parseLegacySize(fields[0] + " " + m[1]); ok {
+							stats["cache_size_bytes"] = v
+						}
+					}
+					continue
+				}
+			}
+
+			key := showStatsKey(section, path)
+			if key == "" {
+				continue
+			}
+			if v, ok := parseShowStatsValue(value); ok {
+				stats[key] = v
+				sections[key] = section
+			}
+			continue
+		}
+
+		match := reLegacyStatsLine.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		label, value := match[1], match[2]
+
+		if label == "cache size" {
+			if v, ok := parseLegacySize(value); ok {
+				stats["cache_size_bytes"] = v
+			}
+			continue
+		}
+
+		key, ok := legacyStatsKeys[label]
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		stats[key] = v
+	}
+
+	return stats, sections, sc.Err()
+}
+
+// showStatsKey returns the '--print-stats' key of a ccache 4.x '--show-stats' section entry, "" if it is not collected.
+func showStatsKey(section statsSection, path string) string {
+	switch section {
+	case sectionUncacheable, sectionErrors:
+		if strings.Contains(path, "/") {
+			return ""
+		}
+		if key, ok := showStatsReasonKeys[path]; ok {
+			return key
+		}
+		return strings.Trim(reNonKeyChars.ReplaceAllString(strings.ToLower(path), "_"), "_")
+	default:
+		return showStatsSectionKeys[section][path]
+	}
+}
+
+// parseShowStatsValue parses the count of a ccache 4.x entry value, e.g. "4891 / 6191 (79.00%)".
+func parseShowStatsValue(s string) (int64, bool) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(fields[0], 10, 64)
+	return v, err == nil
+}
+
+// parseLegacySize parses a size like "2.9 GB" (decimal units, as ccache formats them by default, or binary ones) into bytes.
+func parseLegacySize(s string) (int64, bool) {
+	parts := strings.Fields(s)
+	if len(parts) != 2 {
+		return 0, false
+	}
+
+	v, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	switch parts[1] {
+	case "kB", "KB":
+		v *= 1000
+	case "MB":
+		v *= 1000 * 1000
+	case "GB":
+		v *= 1000 * 1000 * 1000
+	case "TB":
+		v *= 1000 * 1000 * 1000 * 1000
+	case "KiB":
+		v *= 1024
+	case "MiB":
+		v *= 1024 * 1024
+	case "GiB":
+		v *= 1024 * 1024 * 1024
+	case "TiB":
+		v *= 1024 * 1024 * 1024 * 1024
+	case "B", "bytes":
+	default:
+		return 0, false
+	}
+
+	return int64(v), true
+}
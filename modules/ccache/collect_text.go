@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Human-readable header/footer lines that some ccache versions and wrapper scripts print around
+// the machine-readable stats.
+var textStatsDecorationPrefixes = []string{
+	"cache directory",
+	"primary config",
+	"secondary config",
+	"stats updated",
+	"stats zeroed",
+	"stats zero time",
+	"ccache version",
+}
+
+var reTextStatsKey = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// parseStatsText parses 'ccache --print-stats' output: one "<key>\t<value>" pair per line.
+func parseStatsText(bs []byte) (map[string]int64, error) {
+	stats := make(map[string]int64)
+
+	sc := bufio.NewScanner(bytes.NewReader(bs))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || isTextStatsDecoration(line) {
+			continue
+		}
+
+		key, value, ok := parseTextStatsLine(line)
+		if !ok {
+			continue
+		}
+		stats[key] = value
+	}
+
+	return stats, sc.Err()
+}
+
+// parseTextStatsLine parses a "<key>\t<value>" line. Any mix of tabs and spaces is accepted as the separator:
+// the stats can be re-formatted by wrapper scripts or come from Windows build hosts (CRLF line endings).
+func parseTextStatsLine(line string) (string, int64, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 || !reTextStatsKey.MatchString(fields[0]) {
+		return "", 0, false
+	}
+	key, value := fields[0], fields[1]
+
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return key, v, true
+}
+
+func isTextStatsDecoration(line string) bool {
+	line = strings.ToLower(line)
+	for _, px := range textStatsDecorationPrefixes {
+		if strings.HasPrefix(line, px) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// maxDecompressedSize limits the decompressed stats size, the stats are a few KiB.
+const maxDecompressedSize = 16 * 1024 * 1024
+
+// decompressError is returned if the stats look gzip compressed but can not be decompressed,
+// it is distinct from the parse errors of the decompressed stats.
+type decompressError struct {
+	err error
+}
+
+func (e *decompressError) Error() string {
+	return fmt.Sprintf("decompress gzip stats: %v", e.err)
+}
+
+func (e *decompressError) Unwrap() error {
+	return e.err
+}
+
+// maybeDecompress returns the stats decompressed if they are gzip compressed (a wrapper script or an HTTP source
+// can provide compressed stats), detected by the gzip magic bytes. Other data is returned as is.
+func maybeDecompress(bs []byte) ([]byte, error) {
+	if !bytes.HasPrefix(bs, gzipMagic) {
+		return bs, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(bs))
+	if err != nil {
+		return nil, &decompressError{err: err}
+	}
+	defer func() { _ = r.Close() }()
+
+	out, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, &decompressError{err: err}
+	}
+	if len(out) > maxDecompressedSize {
+		return nil, &decompressError{err: fmt.Errorf("decompressed size exceeds %d bytes", maxDecompressedSize)}
+	}
+
+	return out, nil
+}
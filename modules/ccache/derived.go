@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+// derivedMetric is a metric computed from the stats (a ratio, a share, an estimate). The derivations run in order
+// after the stats values are collected and contribute their values to mx, a derivation can use the values of the
+// previous ones (e.g. the estimated I/O saved uses the average object size).
+type derivedMetric struct {
+	name   string
+	derive func(c *Ccache, mx, stats map[string]int64)
+}
+
+var derivedMetrics = []derivedMetric{
+	{name: "hit_ratio", derive: (*Ccache).deriveHitRatio},
+	{name: "cacheable_call_share", derive: (*Ccache).deriveCacheableCallShare},
+	{name: "preprocessing_call_share", derive: (*Ccache).derivePreprocessingCallShare},
+	{name: "store_retrieve_ratio", derive: (*Ccache).deriveStoreRetrieveRatio},
+	{name: "avg_object_size", derive: (*Ccache).deriveAvgObjectSize},
+	{name: "remote_timeout_share", derive: (*Ccache).deriveRemoteTimeoutShare},
+	{name: "recent", derive: (*Ccache).collectRecentStats},
+	{name: "cache_effective", derive: (*Ccache).collectCacheEffective},
+	{name: "last_cleanup", derive: (*Ccache).collectLastCleanup},
+	{name: "eviction_pressure", derive: (*Ccache).collectEvictionPressure},
+	{name: "estimated_io_saved", derive: (*Ccache).collectIOSaved},
+	{name: "estimated_time_saved", derive: (*Ccache).deriveEstimatedTimeSaved},
+	{name: "calls_per_build", derive: (*Ccache).deriveCallsPerBuild},
+	{name: "cache_churn", derive: (*Ccache).collectCacheChurn},
+	{name: "cache_growth", derive: (*Ccache).collectCacheGrowth},
+}
+
+func (c *Ccache) collectDerivedMetrics(mx, stats map[string]int64) {
+	for _, dm := range derivedMetrics {
+		dm.derive(c, mx, stats)
+	}
+}
+
+func (c *Ccache) deriveHitRatio(mx, stats map[string]int64) {
+	newCallsStats(stats).writePercentages(mx, "", c.hitRateBasis())
+}
+
+func (c *Ccache) deriveCacheableCallShare(mx, stats map[string]int64) {
+	calls := newCallsStats(stats)
+
+	mx["cacheable_call_share"] = 0
+	if total := calls.total(); total > 0 {
+		mx["cacheable_call_share"] = (calls.hits + calls.misses) * precision * 100 / total
+	}
+}
+
+// derivePreprocessingCallShare reports the share of the calls for separate preprocessing ('-E', the two-phase
+// preprocess then compile builds ccache can't cache). A high share suggests enabling depend mode, so the builds don't
+// preprocess separately. The chart is added on the first value: the counter is an uncacheable calls one, its key marks
+// the uncacheable calls dimension.
+func (c *Ccache) derivePreprocessingCallShare(mx, stats map[string]int64) {
+	calls, ok := stats["called_for_preprocessing"]
+	if !ok {
+		return
+	}
+
+	mx["preprocessing_call_share"] = 0
+	if total := newCallsStats(stats).total(); total > 0 {
+		mx["preprocessing_call_share"] = calls * precision * 100 / total
+	}
+	if !c.collectedStats["preprocessing_call_share"] {
+		c.collectedStats["preprocessing_call_share"] = true
+		c.addPreprocessingCallShareCharts()
+	}
+}
+
+// deriveStoreRetrieveRatio reports the objects stored per object retrieved. The cache is mostly filling if it stores
+// more objects than it serves, it is normal for a new cache only.
+func (c *Ccache) deriveStoreRetrieveRatio(mx, stats map[string]int64) {
+	calls := newCallsStats(stats)
+
+	stored, ok := stats["local_storage_write"]
+	if !ok {
+		// every cacheable miss is stored
+		stored = calls.misses
+	}
+	mx["store_retrieve_ratio"] = 0
+	if calls.hits > 0 {
+		mx["store_retrieve_ratio"] = stored * precision / calls.hits
+	}
+}
+
+func (c *Ccache) deriveAvgObjectSize(mx, _ map[string]int64) {
+	mx["avg_object_size_bytes"] = 0
+	if files := mx["files_in_cache"]; files > 0 {
+		mx["avg_object_size_bytes"] = mx["cache_size"] / files
+	}
+}
+
+func (c *Ccache) deriveRemoteTimeoutShare(mx, stats map[string]int64) {
+	if _, ok := stats["remote_storage_hit"]; !ok {
+		return
+	}
+
+	// remote operations are reads (hits and misses) and writes; older versions report only hits/misses
+	ops := stats["remote_storage_read_hit"] + stats["remote_storage_read_miss"] + stats["remote_storage_write"]
+	if ops == 0 {
+		ops = stats["remote_storage_hit"] + stats["remote_storage_miss"]
+	}
+	mx["remote_timeout_share"] = 0
+	if ops > 0 {
+		mx["remote_timeout_share"] = stats["remote_storage_timeout"] * precision * 100 / ops
+	}
+}
+
+// deriveEstimatedTimeSaved estimates the share of the compilation time saved by the cache: saved / (saved + actual).
+// A miss costs an average compilation ('avg_compile_seconds'), a hit costs an average cache hit ('avg_hit_seconds')
+// and saves the difference. It is an estimate, the compilation times vary a lot.
+func (c *Ccache) deriveEstimatedTimeSaved(mx, stats map[string]int64) {
+	if !c.estimatesTimeSaved() {
+		return
+	}
+	calls := newCallsStats(stats)
+
+	// float64, the lifetime counters multiplied by the milliseconds can overflow int64
+	compileMs, hitMs := c.AvgCompileSeconds*1000, c.AvgHitSeconds*1000
+	saved := float64(calls.hits) * (compileMs - hitMs)
+	actual := float64(calls.hits)*hitMs + float64(calls.misses)*compileMs
+
+	mx["estimated_time_saved_percent"] = 0
+	if total := saved + actual; total > 0 {
+		mx["estimated_time_saved_percent"] = clampPercentage(int64(saved / total * 100 * precision))
+	}
+}
+
+// estimatesTimeSaved reports whether the estimated time saved is collected: it needs both the compilation and the
+// cache hit average times.
+func (c *Ccache) estimatesTimeSaved() bool {
+	return c.AvgCompileSeconds > 0 && c.AvgHitSeconds > 0
+}
@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_derivedMetrics(t *testing.T) {
+	tests := map[string]struct {
+		prepare   func(c *Ccache)
+		prevStats map[string]int64
+		mx        map[string]int64
+		stats     map[string]int64
+		want      map[string]int64
+	}{
+		"hit_ratio": {
+			stats: map[string]int64{"direct_cache_hit": 60, "preprocessed_cache_hit": 15, "cache_miss": 20, "called_for_link": 5},
+			want: map[string]int64{
+				"cache_hit_percentage":  78947,
+				"cache_miss_percentage": 21052,
+			},
+		},
+		"cacheable_call_share": {
+			stats: map[string]int64{"direct_cache_hit": 60, "cache_miss": 20, "called_for_link": 20},
+			want:  map[string]int64{"cacheable_call_share": 80 * precision},
+		},
+		"preprocessing_call_share": {
+			stats: map[string]int64{"direct_cache_hit": 60, "cache_miss": 20, "called_for_preprocessing": 20},
+			want:  map[string]int64{"preprocessing_call_share": 20 * precision},
+		},
+		"store_retrieve_ratio": {
+			stats: map[string]int64{"direct_cache_hit": 40, "cache_miss": 20, "local_storage_write": 10},
+			want:  map[string]int64{"store_retrieve_ratio": precision / 4},
+		},
+		"avg_object_size": {
+			mx:   map[string]int64{"cache_size": 1000, "files_in_cache": 4},
+			want: map[string]int64{"avg_object_size_bytes": 250},
+		},
+		"remote_timeout_share": {
+			stats: map[string]int64{"remote_storage_hit": 30, "remote_storage_miss": 70, "remote_storage_timeout": 5},
+			want:  map[string]int64{"remote_timeout_share": 5 * precision},
+		},
+		"recent": {
+			prevStats: map[string]int64{"direct_cache_hit": 10, "cache_miss": 10, "called_for_link": 10},
+			stats:     map[string]int64{"direct_cache_hit": 19, "cache_miss": 10, "called_for_link": 11},
+			want: map[string]int64{
+				"recent_cache_hit_percentage":  100 * precision,
+				"recent_cache_miss_percentage": 0,
+				"recent_called_for_link":       1,
+				"hit_rate_trend":               0,
+			},
+		},
+		"cache_effective": {
+			prevStats: map[string]int64{"direct_cache_hit": 10, "cache_miss": 10},
+			mx:        map[string]int64{"recent_cache_hit_percentage": 60 * precision},
+			stats:     map[string]int64{"direct_cache_hit": 16, "cache_miss": 14},
+			want:      map[string]int64{"cache_effective": 1},
+		},
+		"last_cleanup": {
+			prevStats: map[string]int64{"cleanups_performed": 1},
+			stats:     map[string]int64{"cleanups_performed": 2},
+			want:      map[string]int64{"seconds_since_last_cleanup": 0},
+		},
+		"eviction_pressure": {
+			prevStats: map[string]int64{"cleanups_performed": 1, "local_storage_write": 100},
+			stats:     map[string]int64{"cleanups_performed": 2, "local_storage_write": 104},
+			want:      map[string]int64{"cleanup_per_write_ratio": precision / 4},
+		},
+		"estimated_io_saved": {
+			prevStats: map[string]int64{"direct_cache_hit": 10},
+			mx:        map[string]int64{"avg_object_size_bytes": 100},
+			stats:     map[string]int64{"direct_cache_hit": 13},
+			want:      map[string]int64{"estimated_io_saved_bytes": 300},
+		},
+		"cache_growth": {
+			prepare: func(c *Ccache) {
+				now := time.Now()
+				c.sizeSamples = []sizeSample{
+					{time: now.Add(-2 * time.Hour), size: 1000},
+					{time: now.Add(-time.Hour), size: 2000},
+				}
+			},
+			mx:   map[string]int64{"cache_size": 3000},
+			want: map[string]int64{"cache_growth_bytes_per_day": 24 * 1000},
+		},
+		"estimated_time_saved": {
+			prepare: func(c *Ccache) { c.AvgCompileSeconds, c.AvgHitSeconds = 2, 0.5 },
+			stats:   map[string]int64{"direct_cache_hit": 60, "cache_miss": 20},
+			want:    map[string]int64{"estimated_time_saved_percent": 56250},
+		},
+		"calls_per_build": {
+			prepare: func(c *Ccache) {
+				c.BuildCountFile = filepath.Join(t.TempDir(), "builds")
+				require.NoError(t, os.WriteFile(c.BuildCountFile, []byte("8\n"), 0644))
+			},
+			stats: map[string]int64{"direct_cache_hit": 60, "preprocessed_cache_hit": 4, "cache_miss": 20},
+			want:  map[string]int64{"hits_per_build": 8 * precision, "misses_per_build": 2500},
+		},
+		"cache_churn": {
+			prevStats: map[string]int64{"files_in_cache": 10, "local_storage_write": 10, "cleanups_performed": 1},
+			stats:     map[string]int64{"files_in_cache": 8, "local_storage_write": 15, "cleanups_performed": 2},
+			want:      map[string]int64{"files_added_per_interval": 5, "files_removed_per_interval": 7},
+		},
+	}
+
+	for _, dm := range derivedMetrics {
+		require.Containsf(t, tests, dm.name, "derived metric '%s' has no test case", dm.name)
+	}
+
+	for _, dm := range derivedMetrics {
+		test := tests[dm.name]
+		t.Run(dm.name, func(t *testing.T) {
+			c := New()
+			if test.prepare != nil {
+				test.prepare(c)
+			}
+			c.prevStats = test.prevStats
+			mx := make(map[string]int64)
+			for k, v := range test.mx {
+				mx[k] = v
+			}
+
+			dm.derive(c, mx, test.stats)
+
+			for k, v := range test.want {
+				assert.Equalf(t, v, mx[k], "metric '%s'", k)
+			}
+			for k := range mx {
+				_, input := test.mx[k]
+				_, want := test.want[k]
+				assert.Truef(t, input || want, "unexpected metric '%s'", k)
+			}
+		})
+	}
+}
+
+func TestCcache_deriveEstimatedTimeSaved(t *testing.T) {
+	tests := map[string]struct {
+		avgCompileSeconds float64
+		avgHitSeconds     float64
+		stats             map[string]int64
+		want              int64
+		wantNoMetric      bool
+	}{
+		"disabled": {
+			stats:        map[string]int64{"direct_cache_hit": 10, "cache_miss": 10},
+			wantNoMetric: true,
+		},
+		"no calls": {
+			avgCompileSeconds: 1.5,
+			avgHitSeconds:     0.3,
+			stats:             map[string]int64{},
+			want:              0,
+		},
+		"hits only": {
+			avgCompileSeconds: 1.5,
+			avgHitSeconds:     0.3,
+			stats:             map[string]int64{"direct_cache_hit": 10},
+			want:              80 * precision,
+		},
+		"misses only": {
+			avgCompileSeconds: 1.5,
+			avgHitSeconds:     0.3,
+			stats:             map[string]int64{"cache_miss": 10},
+			want:              0,
+		},
+		"the hit cost lowers the saved time": {
+			avgCompileSeconds: 2,
+			avgHitSeconds:     1,
+			stats:             map[string]int64{"direct_cache_hit": 10, "cache_miss": 10},
+			want:              25 * precision,
+		},
+		"huge counters": {
+			avgCompileSeconds: 3600,
+			avgHitSeconds:     360,
+			stats:             map[string]int64{"direct_cache_hit": 1 << 50, "cache_miss": 1 << 50},
+			want:              45 * precision,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := New()
+			c.AvgCompileSeconds = test.avgCompileSeconds
+			c.AvgHitSeconds = test.avgHitSeconds
+			mx := make(map[string]int64)
+
+			c.deriveEstimatedTimeSaved(mx, test.stats)
+
+			v, ok := mx["estimated_time_saved_percent"]
+			if test.wantNoMetric {
+				assert.False(t, ok)
+				return
+			}
+			assert.Equal(t, test.want, v)
+		})
+	}
+}
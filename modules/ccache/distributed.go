@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// distributedCompilers are the distributed compilation tools ccache is combined with through its 'prefix_command'.
+// ccache runs the prefix for the compilations of the cache misses only: the hits, the preprocessing and the uncacheable
+// calls ('called_for_link', 'called_for_preprocessing', ...) are handled locally, before anything is distributed.
+var distributedCompilers = []string{"distcc", "icecc"}
+
+// resolveDistributedCompiler returns the distributed compiler ccache hands the misses to ('distcc', 'icecc'),
+// empty if none. It is the ccache 'prefix_command': $CCACHE_PREFIX, then the ccache config file (resolveConfigPath).
+func (c *Ccache) resolveDistributedCompiler() string {
+	prefix := c.getenv("CCACHE_PREFIX")
+
+	if prefix == "" {
+		if path := c.resolveConfigPath(); path != "" {
+			if bs, err := os.ReadFile(path); err == nil {
+				prefix = parseConfigValue(bs, "prefix_command")
+			}
+		}
+	}
+
+	// the prefix is a command line, e.g. '/usr/bin/icecc' or 'pump distcc'
+	for _, field := range strings.Fields(prefix) {
+		name := strings.TrimSuffix(filepath.Base(field), ".exe")
+		for _, dc := range distributedCompilers {
+			if name == dc {
+				return dc
+			}
+		}
+	}
+	return ""
+}
+
+// parseConfigValue returns the value of the key in a ccache config file ('key = value' lines, '#' comments).
+func parseConfigValue(bs []byte, key string) string {
+	var value string
+
+	sc := bufio.NewScanner(bytes.NewReader(bs))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if ok && strings.TrimSpace(k) == key {
+			// the last occurrence wins, like in ccache
+			value = strings.TrimSpace(v)
+		}
+	}
+	return value
+}
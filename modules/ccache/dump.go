@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const defaultDumpFileMaxSize = 10 * 1024 * 1024
+
+// dumpRecord is a 'dump_file' line.
+type dumpRecord struct {
+	Timestamp int64            `json:"timestamp"`
+	Metrics   map[string]int64 `json:"metrics"`
+}
+
+// dumpMetrics appends the collected metrics to 'dump_file' as a JSON line, for offline analysis.
+// Write errors don't fail the collection, they are logged once until a write succeeds again.
+func (c *Ccache) dumpMetrics(mx map[string]int64) {
+	if err := c.writeDump(mx); err != nil {
+		if !c.dumpFailed {
+			c.Warningf("dump metrics to '%s': %v", c.DumpFile, err)
+		}
+		c.dumpFailed = true
+		return
+	}
+	c.dumpFailed = false
+}
+
+// writeDump appends a record to the dump file. The file is rotated ('<dump_file>.1', the previous one is overwritten)
+// when it would grow beyond 'dump_file_max_size'.
+func (c *Ccache) writeDump(mx map[string]int64) error {
+	bs, err := json.Marshal(dumpRecord{Timestamp: time.Now().Unix(), Metrics: mx})
+	if err != nil {
+		return err
+	}
+	bs = append(bs, '\n')
+
+	if fi, err := os.Stat(c.DumpFile); err == nil && fi.Size()+int64(len(bs)) > c.DumpFileMaxSize {
+		if err := os.Rename(c.DumpFile, c.DumpFile+".1"); err != nil {
+			return fmt.Errorf("rotate: %v", err)
+		}
+	}
+
+	f, err := os.OpenFile(c.DumpFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(bs); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,259 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netdata/go.d.plugin/logger"
+)
+
+func newCcacheExec(ctx context.Context, binPath string, cfg Config, log *logger.Logger) *ccacheExec {
+	e := &ccacheExec{
+		Logger:     log,
+		ctx:        ctx,
+		binPath:    binPath,
+		cacheDir:   cfg.CacheDir,
+		dirEnv:     "CCACHE_DIR",
+		configPath: cfg.ConfigPath,
+		configEnv:  "CCACHE_CONFIGPATH",
+		env:        cfg.Environment,
+		wrapper:    cfg.CommandWrapper,
+		cgroup:     &execCgroup{path: cfg.Cgroup},
+		safeMode:   cfg.SafeMode,
+		timeout:    cfg.Timeout.Duration,
+	}
+	if tool(cfg.Tool) == toolSccache {
+		e.dirEnv = "SCCACHE_DIR"
+		e.configEnv = "SCCACHE_CONF"
+	}
+	return e
+}
+
+type ccacheExec struct {
+	*logger.Logger
+
+	// ctx is the job context, it is cancelled on Cleanup() and kills a running ccache.
+	ctx      context.Context
+	binPath  string
+	cacheDir string
+	// dirEnv is the cache directory environment variable of the tool.
+	dirEnv     string
+	configPath string
+	// configEnv is the config file environment variable of the tool.
+	configEnv string
+	// env is the 'environment' option, the variables set for every execution.
+	env map[string]string
+	// wrapper is the 'command_wrapper' the binary is run through, empty if not set.
+	wrapper []string
+	// cgroup is the 'cgroup' the executions are placed into, the exec copies (see withContext) share it.
+	cgroup *execCgroup
+	// safeMode is the 'safe_mode' guard, the job config validation doesn't let an exec be created in safe mode.
+	safeMode bool
+	timeout  time.Duration
+}
+
+// execCgroup is the 'cgroup' option path, empty if not set (or if the placement failed). The function executions
+// may drop it concurrently with the collection.
+type execCgroup struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (g *execCgroup) get() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.path
+}
+
+func (g *execCgroup) drop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.path = ""
+}
+
+// withContext returns a copy of the exec that runs the commands in ctx (e.g. a function request context),
+// a cancelled ctx kills the running process.
+func (e *ccacheExec) withContext(ctx context.Context) *ccacheExec {
+	ec := *e
+	ec.ctx = ctx
+	return &ec
+}
+
+func (e *ccacheExec) version() ([]byte, error) {
+	return e.execute("--version")
+}
+
+func (e *ccacheExec) help() ([]byte, error) {
+	return e.execute("--help")
+}
+
+func (e *ccacheExec) printStatsJSON() ([]byte, error) {
+	return e.execute("--print-stats", "--format=json")
+}
+
+func (e *ccacheExec) printStats() ([]byte, error) {
+	return e.execute("--print-stats")
+}
+
+func (e *ccacheExec) showStats(flag string) ([]byte, error) {
+	return e.execute(flag)
+}
+
+// printConfig prints the configuration: '-p' is '--print-config' in ccache 3.x and '--show-config' in 4.x.
+func (e *ccacheExec) printConfig() ([]byte, error) {
+	return e.execute("-p")
+}
+
+func (e *ccacheExec) sccacheStats() ([]byte, error) {
+	return e.execute("--show-stats", "--stats-format=json")
+}
+
+// errSafeMode is returned instead of executing a process in 'safe_mode'.
+var errSafeMode = errors.New("executing processes is forbidden in 'safe_mode'")
+
+func (e *ccacheExec) execute(arg ...string) ([]byte, error) {
+	if e.safeMode {
+		return nil, errSafeMode
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, e.timeout)
+	defer cancel()
+
+	name, args := e.binPath, arg
+	if len(e.wrapper) > 0 {
+		// e.g. 'podman unshare ccache --print-stats'
+		name = e.wrapper[0]
+		args = append(append(slices.Clone(e.wrapper[1:]), e.binPath), arg...)
+	}
+
+	cgroup := e.cgroup.get()
+	bs, err := e.run(ctx, name, args, cgroup)
+	if err != nil && cgroup != "" && !isExitError(err) {
+		// the process didn't start: the cgroup may be the cause (no permission, removed, a kernel without
+		// CLONE_INTO_CGROUP), the cgroup is dropped if the command starts without it
+		bs2, err2 := e.run(ctx, name, args, "")
+		if err2 == nil || isExitError(err2) {
+			e.Warningf("can not place '%s' into cgroup '%s' (%v), running it without the cgroup", name, cgroup, err)
+			e.cgroup.drop()
+			bs, err = bs2, err2
+		}
+	}
+	return bs, err
+}
+
+func (e *ccacheExec) run(ctx context.Context, name string, args []string, cgroup string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = e.environ()
+	// the child reads the null device: a misconfigured ccache (or wrapper) prompting for input gets EOF instead of
+	// blocking until the timeout, and it can't consume the plugin's stdin (the agent's requests)
+	cmd.Stdin = nil
+	if cgroup != "" {
+		closeCgroup, err := setCmdCgroup(cmd, cgroup)
+		if err != nil {
+			return nil, newExecError(cmd.String(), err)
+		}
+		defer closeCgroup()
+	}
+
+	e.Debugf("executing '%s'", cmd)
+
+	bs, err := cmd.Output()
+	if err != nil {
+		ee := newExecError(cmd.String(), err)
+		ee.wrapped = len(e.wrapper) > 0
+		ee.timedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+		return nil, ee
+	}
+
+	return bs, nil
+}
+
+func isExitError(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr)
+}
+
+// forcedLocale is the locale of the executions: the numbers and dates in the output are always in the canonical
+// format, whatever the host locale is (no per parser localized formats handling).
+var forcedLocale = []string{"LC_ALL=C", "LANG=C"}
+
+// environ returns the environment of the executions: the agent environment, the 'environment' variables and
+// the forced C locale. It is the same for all the commands: the stats and the version/help probes see the same
+// effective ccache config. The 'cache_dir' and 'config_path' options take precedence over the 'environment' variables.
+// The later values of a variable override the earlier ones.
+func (e *ccacheExec) environ() []string {
+	keys := make([]string, 0, len(e.env))
+	for k := range e.env {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	env := os.Environ()
+	for _, k := range keys {
+		env = append(env, k+"="+e.env[k])
+	}
+	env = append(env, forcedLocale...)
+	if e.configPath != "" {
+		env = append(env, e.configEnv+"="+e.configPath)
+	}
+	if e.cacheDir != "" {
+		env = append(env, e.dirEnv+"="+e.cacheDir)
+	}
+	return env
+}
+
+// execError carries the context of a failed ccache execution, it is logged as structured fields.
+type execError struct {
+	cmd      string
+	exitCode int
+	stderr   string
+	// wrapped is set if the command was run through 'command_wrapper' (it may have failed entering the namespace).
+	wrapped bool
+	// timedOut is set if the command was killed on the 'timeout'.
+	timedOut bool
+	err      error
+}
+
+func newExecError(cmd string, err error) *execError {
+	e := &execError{cmd: cmd, exitCode: -1, err: err}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		e.exitCode = exitErr.ExitCode()
+		e.stderr = strings.TrimSpace(string(exitErr.Stderr))
+	}
+
+	return e
+}
+
+func (e *execError) Error() string {
+	return fmt.Sprintf("error on '%s': %v", e.cmd, e.err)
+}
+
+func (e *execError) Unwrap() error {
+	return e.err
+}
+
+func (e *execError) logAttrs() []any {
+	attrs := []any{
+		slog.String("cmd", e.cmd),
+		slog.Int("exit_code", e.exitCode),
+	}
+	if e.stderr != "" {
+		attrs = append(attrs, slog.String("stderr", e.stderr))
+	}
+	if e.wrapped {
+		attrs = append(attrs, slog.Bool("command_wrapper", true))
+	}
+	return attrs
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fifoPollInterval is how often the FIFO is re-read while there is no writer.
+const fifoPollInterval = time.Millisecond * 50
+
+// queryFIFO reads one stats dump (json or text) a sidecar writes to 'fifo_path'.
+// The FIFO is opened non-blocking, so the open doesn't hang without a writer. A dump is complete when the writer
+// closes its end; the collection waits for a writer and for the dump at most 'timeout'.
+func (c *Ccache) queryFIFO() (map[string]int64, error) {
+	f, err := os.OpenFile(c.FIFOPath, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open fifo: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	deadline := time.Now().Add(c.Timeout.Duration)
+	if err := f.SetReadDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("fifo '%s': %v", c.FIFOPath, err)
+	}
+
+	var buf bytes.Buffer
+	for {
+		// reads until the writer closes its end (EOF), reading returns EOF right away if there is no writer
+		_, err := buf.ReadFrom(io.LimitReader(f, maxDecompressedSize))
+		switch {
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			if buf.Len() > 0 {
+				return nil, fmt.Errorf("fifo '%s': partial stats dump (%d bytes), the writer did not finish in %s",
+					c.FIFOPath, buf.Len(), c.Timeout.Duration)
+			}
+			return nil, fmt.Errorf("fifo '%s': no writer in %s", c.FIFOPath, c.Timeout.Duration)
+		case err != nil:
+			return nil, fmt.Errorf("read fifo '%s': %v", c.FIFOPath, err)
+		}
+		if buf.Len() > 0 {
+			break
+		}
+
+		if time.Now().Add(fifoPollInterval).After(deadline) {
+			return nil, fmt.Errorf("fifo '%s': no writer in %s", c.FIFOPath, c.Timeout.Duration)
+		}
+		select {
+		case <-c.ctx.Done():
+			return nil, fmt.Errorf("fifo '%s': %v", c.FIFOPath, c.ctx.Err())
+		case <-time.After(fifoPollInterval):
+		}
+	}
+
+	bs, err := maybeDecompress(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("fifo '%s': %w", c.FIFOPath, err)
+	}
+
+	return parseStatsJSONOrText(bs)
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+//go:build linux
+// +build linux
+
+package ccache
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/netdata/go.d.plugin/pkg/web"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCcache_Collect_FIFO(t *testing.T) {
+	tests := map[string]struct {
+		writer  func(t *testing.T, path string)
+		wantErr string
+	}{
+		"complete dump": {
+			writer: func(t *testing.T, path string) {
+				f, err := os.OpenFile(path, os.O_WRONLY, 0)
+				require.NoError(t, err)
+				_, _ = f.Write(dataVer48PrintStats)
+				_ = f.Close()
+			},
+		},
+		"no writer": {
+			wantErr: "no writer",
+		},
+		"partial dump": {
+			writer: func(t *testing.T, path string) {
+				f, err := os.OpenFile(path, os.O_WRONLY, 0)
+				require.NoError(t, err)
+				_, _ = f.Write(dataVer48PrintStats[:100])
+				time.Sleep(time.Second) // the writer hangs, the dump is not complete
+				_ = f.Close()
+			},
+			wantErr: "partial stats dump",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "stats.fifo")
+			require.NoError(t, syscall.Mkfifo(path, 0600))
+
+			c := New()
+			c.CollectionMode = string(collectionModeFIFO)
+			c.FIFOPath = path
+			c.Timeout = web.Duration{Duration: time.Millisecond * 300}
+			require.True(t, c.Init())
+
+			if test.writer != nil {
+				go test.writer(t, path)
+			}
+
+			start := time.Now()
+			mx, err := c.collect()
+			assert.Less(t, time.Since(start), time.Millisecond*800, "reading the fifo must respect 'timeout'")
+
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, int64(1300), mx["cache_miss"])
+		})
+	}
+}
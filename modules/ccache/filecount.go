@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// defaultFileCountMaxDiscrepancy is the default 'file_count_max_discrepancy', in percent of 'files_in_cache'.
+const defaultFileCountMaxDiscrepancy = 10
+
+// collectFileCountDiscrepancy compares the reported 'files_in_cache' with the number of the cache files on disk
+// ('file_count_check', 'file' collection mode). The stats files are updated by ccache as it adds and evicts files,
+// a large divergence means a stale stats file or a cache modified behind ccache's back. The discrepancy is the
+// on disk count minus the reported one, it is computed at the count time and reused until the next count.
+func (c *Ccache) collectFileCountDiscrepancy(mx, stats map[string]int64) {
+	now := time.Now()
+	if !c.hasFileCount || now.Sub(c.fileCountTime) >= c.fileCountEvery {
+		// the directory walk is expensive, it is done at most once per 'fileCountEvery'
+		c.fileCountTime = now
+		ctx, cancel := context.WithTimeout(c.ctx, c.Timeout.Duration)
+		counts, err := countShardsFiles(ctx, c.cacheDir)
+		cancel()
+		if err != nil {
+			c.Warningf("count '%s' cache files: %v", c.cacheDir, err)
+			return
+		}
+
+		var onDisk int64
+		for _, v := range counts {
+			onDisk += v
+		}
+		c.fileCountDiscrepancy, c.hasFileCount = onDisk-stats["files_in_cache"], true
+		c.checkFileCountDiscrepancy(onDisk, stats["files_in_cache"])
+	}
+
+	mx["file_count_discrepancy"] = c.fileCountDiscrepancy
+}
+
+// checkFileCountDiscrepancy warns once if the discrepancy exceeds 'file_count_max_discrepancy', until it is back
+// below the threshold.
+func (c *Ccache) checkFileCountDiscrepancy(onDisk, reported int64) {
+	diff := math.Abs(float64(onDisk - reported))
+	exceeded := diff > float64(max(reported, 1))*c.FileCountMaxDiscrepancy/100
+
+	switch {
+	case exceeded && !c.fileCountWarned:
+		c.Warningf("'%s' has %d cache files on disk, ccache reports %d (more than %v%% apart): "+
+			"the stats file may be stale or the cache was modified outside of ccache",
+			c.cacheDir, onDisk, reported, c.FileCountMaxDiscrepancy)
+	case !exceeded && c.fileCountWarned:
+		c.Infof("'%s' cache files count matches the reported one again", c.cacheDir)
+	}
+	c.fileCountWarned = exceeded
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// functionResult is the 'module_function' response: the stats command output and the parsed counters.
+type functionResult struct {
+	Version     string           `json:"version"`
+	StatsFormat string           `json:"stats_format"`
+	Command     string           `json:"command"`
+	Raw         string           `json:"raw"`
+	Stats       map[string]int64 `json:"stats"`
+}
+
+// HandleFunction runs the ccache stats command on demand, it is killed if ctx is cancelled.
+// User names in home directory paths are redacted from the raw output.
+// The 'openmetrics' argument renders the last collected metrics in the OpenMetrics format instead, in any collection
+// mode.
+func (c *Ccache) HandleFunction(ctx context.Context, args []string) ([]byte, error) {
+	if len(args) > 0 {
+		if args[0] != openMetricsFunction {
+			return nil, fmt.Errorf("unknown function argument '%s' (supported: '%s')", args[0], openMetricsFunction)
+		}
+		return c.runOpenMetricsFunction()
+	}
+	if collectionMode(c.CollectionMode) != collectionModeExec {
+		return nil, fmt.Errorf("function is supported only in '%s' collection mode", collectionModeExec)
+	}
+	if c.exec == nil {
+		return nil, errors.New("ccache exec is not initialized")
+	}
+
+	cli := c.exec
+	if e, ok := c.exec.(*ccacheExec); ok {
+		// the function has its own exec: the request cancellation kills the process
+		cli = e.withContext(ctx)
+	}
+
+	res, err := c.runStatsFunction(cli)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("run %s stats: %v", c.toolName(), ctx.Err())
+		}
+		return nil, err
+	}
+
+	return json.Marshal(res)
+}
+
+// runStatsFunction runs the stats command once, with the format state snapshot taken at the start:
+// the collection may renegotiate the format concurrently.
+func (c *Ccache) runStatsFunction(cli ccacheCLI) (*functionResult, error) {
+	format, flag, version := c.negotiatedStatsFormat()
+
+	bs, parse, err := execStatsCommand(cli, format, flag)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := parse(bs)
+	if err != nil {
+		return nil, err
+	}
+	normalizeStatsKeys(stats)
+
+	return &functionResult{
+		Version:     version,
+		StatsFormat: string(format),
+		Command:     c.toolName() + " " + statsCommandArgs(format, flag),
+		Raw:         string(redactHomeDirs(bs)),
+		Stats:       stats,
+	}, nil
+}
+
+// statsCommandArgs returns the arguments of the format stats command.
+func statsCommandArgs(format statsFormat, showStatsFlag string) string {
+	switch format {
+	case statsFormatJSON:
+		return "--print-stats --format=json"
+	case statsFormatText:
+		return "--print-stats"
+	case statsFormatSccache:
+		return "--show-stats --stats-format=json"
+	default:
+		return showStatsFlag
+	}
+}
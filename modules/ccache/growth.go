@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// growthSampleEvery and growthSamples bound the cache size history: a sample every 10 minutes, the last 24 hours.
+	growthSampleEvery = 10 * time.Minute
+	growthSamples     = 144
+	// growthMinSpan is the history span the growth rate is reported after, shorter spans are too noisy.
+	growthMinSpan = time.Hour
+	// growthFillWarnWithin is how close the projected max size date has to be to log a warning.
+	growthFillWarnWithin = 7 * 24 * time.Hour
+)
+
+type sizeSample struct {
+	time time.Time
+	size int64
+}
+
+// collectCacheGrowth reports the cache growth rate for forecasting when the cache volume fills. The rate is the least
+// squares fit of the cache size history, not the last delta: a cleanup drop lowers it instead of swinging it to
+// a large negative rate.
+// The fit is recomputed only when a sample is taken.
+func (c *Ccache) collectCacheGrowth(mx, _ map[string]int64) {
+	now := time.Now()
+	if n := len(c.sizeSamples); n == 0 || now.Sub(c.sizeSamples[n-1].time) >= growthSampleEvery {
+		c.sizeSamples = append(c.sizeSamples, sizeSample{time: now, size: mx["cache_size"]})
+		if len(c.sizeSamples) > growthSamples {
+			c.sizeSamples = slices.Delete(c.sizeSamples, 0, 1)
+		}
+		c.cacheGrowth, c.hasCacheGrowth = fitGrowthRate(c.sizeSamples)
+	}
+	if !c.hasCacheGrowth {
+		return
+	}
+
+	mx["cache_growth_bytes_per_day"] = c.cacheGrowth
+	if !c.collectedStats["cache_growth_bytes_per_day"] {
+		c.collectedStats["cache_growth_bytes_per_day"] = true
+		c.addCacheGrowthCharts()
+	}
+	c.checkProjectedFill(mx["cache_size"])
+}
+
+// fitGrowthRate returns the slope (bytes per day) of the linear least squares fit of the samples.
+func fitGrowthRate(samples []sizeSample) (int64, bool) {
+	if len(samples) < 2 || samples[len(samples)-1].time.Sub(samples[0].time) < growthMinSpan {
+		return 0, false
+	}
+
+	n := float64(len(samples))
+	var sumX, sumY float64
+	for _, s := range samples {
+		sumX += s.time.Sub(samples[0].time).Seconds()
+		sumY += float64(s.size)
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var cov, variance float64
+	for _, s := range samples {
+		dx := s.time.Sub(samples[0].time).Seconds() - meanX
+		cov += dx * (float64(s.size) - meanY)
+		variance += dx * dx
+	}
+	if variance == 0 {
+		return 0, false
+	}
+	return int64(math.Round(cov / variance * (24 * time.Hour).Seconds())), true
+}
+
+// checkProjectedFill warns (once, until the projection moves away) if the cache is projected to reach its max size
+// soon. ccache evicts the old entries from then on, the hit ratio usually drops.
+func (c *Ccache) checkProjectedFill(size int64) {
+	if c.cacheMaxSize <= 0 || c.cacheGrowth <= 0 || size >= c.cacheMaxSize {
+		c.fillWarned = false
+		return
+	}
+
+	eta := time.Duration(float64(c.cacheMaxSize-size) / float64(c.cacheGrowth) * float64(24*time.Hour))
+	if eta >= growthFillWarnWithin {
+		c.fillWarned = false
+		return
+	}
+	if !c.fillWarned {
+		c.fillWarned = true
+		c.Warningf("cache is projected to reach its max size (%d bytes) in %s (on %s), at %d bytes/day",
+			c.cacheMaxSize, eta.Round(time.Hour), time.Now().Add(eta).Format(time.DateOnly), c.cacheGrowth)
+	}
+}
+
+// resolveCacheMaxSize returns the ccache max cache size in bytes: $CCACHE_MAXSIZE, the config file 'max_size'.
+// 0 if not configured (or unlimited).
+func (c *Ccache) resolveCacheMaxSize() int64 {
+	v := c.getenv("CCACHE_MAXSIZE")
+	if v == "" {
+		if path := c.resolveConfigPath(); path != "" {
+			if bs, err := os.ReadFile(path); err == nil {
+				v = parseConfigValue(bs, "max_size")
+			}
+		}
+	}
+	size, _ := parseCcacheSize(v)
+	return size
+}
+
+// ccacheSizeSuffixes are the ccache size units, decimal (k, M, G, T) and binary (Ki, Mi, Gi, Ti).
+var ccacheSizeSuffixes = []struct {
+	suffix string
+	mul    float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+}
+
+// parseCcacheSize parses a ccache size ('5G', '500Mi', '1.5'); a number without a suffix is in gigabytes, like in ccache.
+func parseCcacheSize(s string) (int64, bool) {
+	s = strings.TrimSpace(s)
+	mul := 1e9
+	for _, v := range ccacheSizeSuffixes {
+		if strings.HasSuffix(s, v.suffix) {
+			s, mul = strings.TrimSpace(strings.TrimSuffix(s, v.suffix)), v.mul
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, false
+	}
+	return int64(f * mul), true
+}
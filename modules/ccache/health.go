@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"time"
+)
+
+// HealthSummary is a compact, read-only summary of the collector state, derived from Init() and the last collection.
+type HealthSummary struct {
+	CollectionMode string
+	BinaryFound    bool
+	Version        string
+	StatsFormat    string
+	// ActiveSource is the source the last stats came from ('sources' collection mode).
+	ActiveSource string
+
+	LastCollectTime    time.Time
+	LastCollectSuccess bool
+	LastCollectError   string
+	// CoreMetricsPresent reports whether the last collection had the hits, misses and cache files metrics.
+	CoreMetricsPresent bool
+}
+
+var coreMetrics = []string{
+	"direct_cache_hit",
+	"preprocessed_cache_hit",
+	"cache_miss",
+	"files_in_cache",
+}
+
+// Health returns the collector health summary, intended for integration tests and tooling.
+func (c *Ccache) Health() HealthSummary {
+	h := c.health
+	h.CollectionMode = c.CollectionMode
+	h.BinaryFound = c.exec != nil
+	h.Version = c.version
+	h.StatsFormat = string(c.statsFormat)
+	h.ActiveSource = c.activeSource
+	return h
+}
+
+func (c *Ccache) updateHealth(mx map[string]int64, err error) {
+	c.health.LastCollectTime = time.Now()
+	c.health.LastCollectSuccess = err == nil && len(mx) > 0
+	c.health.LastCollectError = ""
+	if err != nil {
+		c.health.LastCollectError = err.Error()
+	}
+
+	c.health.CoreMetricsPresent = len(mx) > 0
+	for _, key := range coreMetrics {
+		if _, ok := mx[key]; !ok {
+			c.health.CoreMetricsPresent = false
+			break
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/blang/semver/v4"
+)
+
+func (c *Ccache) validateConfig() error {
+	switch collectionMode(c.CollectionMode) {
+	case collectionModeExec:
+		if c.BinaryPath == "" {
+			return errors.New("'binary_path' can not be empty")
+		}
+	case collectionModeFile, collectionModeNodes, collectionModeURL:
+	case collectionModeDirs:
+		if c.CacheDirsRoot == "" {
+			return fmt.Errorf("'cache_dirs_root' can not be empty in '%s' collection mode", collectionModeDirs)
+		}
+	case collectionModeFIFO:
+		if c.FIFOPath == "" {
+			return fmt.Errorf("'fifo_path' can not be empty in '%s' collection mode", collectionModeFIFO)
+		}
+	case collectionModeSources:
+		if err := validateSources(c.Sources); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown 'collection_mode' '%s' (supported: '%s', '%s', '%s', '%s', '%s', '%s', '%s')",
+			c.CollectionMode, collectionModeExec, collectionModeFile, collectionModeNodes, collectionModeURL,
+			collectionModeDirs, collectionModeFIFO, collectionModeSources)
+	}
+
+	if c.EffectiveHitRateThreshold < 0 || c.EffectiveHitRateThreshold > 100 {
+		return fmt.Errorf("'effective_hit_rate_threshold' must be between 0 and 100, got %v", c.EffectiveHitRateThreshold)
+	}
+
+	if c.SafeMode {
+		if err := c.validateSafeMode(); err != nil {
+			return err
+		}
+	}
+
+	if len(c.CommandWrapper) > 0 && c.CommandWrapper[0] == "" {
+		return errors.New("'command_wrapper' command can not be empty")
+	}
+
+	for k := range c.Environment {
+		if k == "" || strings.ContainsAny(k, "=\x00") {
+			return fmt.Errorf("invalid 'environment' variable name '%s'", k)
+		}
+	}
+
+	switch tool(c.Tool) {
+	case "", toolCcache:
+	case toolSccache:
+		if collectionMode(c.CollectionMode) != collectionModeExec {
+			return fmt.Errorf("'tool' '%s' is supported only in '%s' collection mode", toolSccache, collectionModeExec)
+		}
+	default:
+		return fmt.Errorf("unknown 'tool' '%s' (supported: '%s', '%s')", c.Tool, toolCcache, toolSccache)
+	}
+
+	switch hitRateBasis(c.HitRateBasis) {
+	case "", hitRateBasisHitsMisses, hitRateBasisAllCalls:
+	default:
+		return fmt.Errorf("unknown 'hit_rate_basis' '%s' (supported: '%s', '%s')",
+			c.HitRateBasis, hitRateBasisHitsMisses, hitRateBasisAllCalls)
+	}
+
+	if c.UnknownKeyPolicy != "" {
+		if err := validateUnknownKeyPolicy(c.UnknownKeyPolicy); err != nil {
+			return err
+		}
+	}
+
+	if c.AvgCompileSeconds < 0 {
+		return fmt.Errorf("'avg_compile_seconds' can not be negative, got %v", c.AvgCompileSeconds)
+	}
+	if c.AvgHitSeconds < 0 {
+		return fmt.Errorf("'avg_hit_seconds' can not be negative, got %v", c.AvgHitSeconds)
+	}
+	if (c.AvgCompileSeconds > 0) != (c.AvgHitSeconds > 0) {
+		return errors.New("'avg_compile_seconds' and 'avg_hit_seconds' must be set together")
+	}
+	if c.AvgCompileSeconds > 0 && c.AvgHitSeconds >= c.AvgCompileSeconds {
+		return fmt.Errorf("'avg_hit_seconds' (%v) must be less than 'avg_compile_seconds' (%v)",
+			c.AvgHitSeconds, c.AvgCompileSeconds)
+	}
+
+	if c.ExpectedVersion != "" && !reExpectedVersion.MatchString(c.ExpectedVersion) {
+		return fmt.Errorf("invalid 'expected_version' '%s', expected 'major[.minor[.patch]]'", c.ExpectedVersion)
+	}
+
+	if c.FileCountMaxDiscrepancy < 0 {
+		return fmt.Errorf("'file_count_max_discrepancy' can not be negative, got %v", c.FileCountMaxDiscrepancy)
+	}
+
+	if c.DumpFile != "" && c.DumpFileMaxSize <= 0 {
+		return fmt.Errorf("'dump_file_max_size' must be positive, got %d", c.DumpFileMaxSize)
+	}
+
+	if c.ContextPrefix != "" && !reContextPrefix.MatchString(c.ContextPrefix) {
+		return fmt.Errorf("invalid 'context_prefix' '%s' (allowed: dot separated letters, digits and underscores)", c.ContextPrefix)
+	}
+
+	for name, v := range map[string]string{"title_prefix": c.TitlePrefix, "family": c.Family} {
+		if err := validateChartText(v); err != nil {
+			return fmt.Errorf("invalid '%s': %v", name, err)
+		}
+	}
+
+	if c.Priority < 0 || c.Priority > maxPriority {
+		return fmt.Errorf("'priority' must be between 1 and %d, got %d", maxPriority, c.Priority)
+	}
+
+	return nil
+}
+
+// validateSafeMode checks no configured collection path executes a process.
+func (c *Ccache) validateSafeMode() error {
+	switch collectionMode(c.CollectionMode) {
+	case collectionModeExec:
+		return fmt.Errorf("'safe_mode' forbids executing %s, configure a non-exec 'collection_mode' (e.g. '%s', '%s' or '%s')",
+			c.toolName(), collectionModeFile, collectionModeURL, collectionModeFIFO)
+	case collectionModeSources:
+		for _, name := range c.Sources {
+			if isExecSource(name) {
+				return fmt.Errorf("'safe_mode' forbids the '%s' source", name)
+			}
+		}
+	}
+	if len(c.CommandWrapper) > 0 {
+		return errors.New("'safe_mode' forbids 'command_wrapper'")
+	}
+	return nil
+}
+
+// reContextPrefix matches a context safe prefix, e.g. 'staging' or 'eu_west.staging'.
+var reContextPrefix = regexp.MustCompile(`^[a-zA-Z0-9_]+(?:\.[a-zA-Z0-9_]+)*$`)
+
+// maxChartTextLen limits the 'title_prefix' and 'family' length, they are shown in the dashboard menus and headers.
+const maxChartTextLen = 100
+
+// validateChartText checks a chart title/family override can be passed to Netdata as is: the chart definition
+// fields are single quoted, one definition per line.
+func validateChartText(s string) error {
+	if len(s) > maxChartTextLen {
+		return fmt.Errorf("longer than %d characters", maxChartTextLen)
+	}
+	for _, r := range s {
+		if r == '\'' || unicode.IsControl(r) {
+			return fmt.Errorf("contains %q, quotes and control characters are not allowed", r)
+		}
+	}
+	return nil
+}
+
+// maxPriority keeps the shifted charts priorities well within the Netdata priority range.
+const maxPriority = 100_000_000
+
+func (c *Ccache) initCcacheExec() (ccacheCLI, error) {
+	if len(c.CommandWrapper) > 0 {
+		if _, err := exec.LookPath(c.CommandWrapper[0]); err != nil {
+			return nil, fmt.Errorf("'command_wrapper': %v", err)
+		}
+		// the binary is run by the wrapper (e.g. in a user namespace), it is looked up by the wrapper too
+		return newCcacheExec(c.ctx, c.BinaryPath, c.Config, c.Logger), nil
+	}
+
+	binPath, err := exec.LookPath(c.BinaryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCcacheExec(c.ctx, binPath, c.Config, c.Logger), nil
+}
+
+// resolveBuildID returns the CI build (pipeline/job) identity: 'build_id' option, then the 'build_id_env' environment variable.
+func (c *Ccache) resolveBuildID() string {
+	if c.BuildID != "" {
+		return c.BuildID
+	}
+	if c.BuildIDEnv != "" {
+		return os.Getenv(c.BuildIDEnv)
+	}
+	return ""
+}
+
+var (
+	reVersion = regexp.MustCompile(`ccache version (\d+\.\d+(?:\.\d+)?)`)
+
+	// '--print-stats' (machine-readable, tab separated) was added in ccache 3.7.
+	printStatsMinVer = semver.Version{Major: 3, Minor: 7}
+	// Long options ('--show-stats') were added in ccache 3.0, older versions support only '-s'.
+	showStatsLongMinVer = semver.Version{Major: 3}
+)
+
+// negotiateStatsFormat probes the installed ccache once and picks the best stats format it supports:
+// json > machine-readable text > legacy human-readable '--show-stats'.
+func (c *Ccache) negotiateStatsFormat() (statsFormat, error) {
+	bs, err := c.exec.version()
+	if err != nil {
+		return "", fmt.Errorf("exec %s --version: %w", c.toolName(), err)
+	}
+
+	ver, err := c.parseToolVersion(bs)
+	if err != nil {
+		return "", err
+	}
+	c.Debugf("found %s version %s", c.toolName(), ver)
+
+	format, flag := statsFormatSccache, "--show-stats"
+	if tool(c.Tool) != toolSccache {
+		help, err := c.exec.help()
+		if err != nil {
+			c.logger(err).Warningf("exec ccache --help: %v (selecting stats format based on version)", err)
+		}
+		format, flag = selectStatsFormat(ver, help), selectShowStatsFlag(ver, help)
+	}
+
+	c.formatMux.Lock()
+	c.version, c.showStatsFlag = ver.String(), flag
+	c.formatMux.Unlock()
+
+	return format, nil
+}
+
+func (c *Ccache) setStatsFormat(f statsFormat) {
+	c.formatMux.Lock()
+	defer c.formatMux.Unlock()
+	c.statsFormat = f
+}
+
+// negotiatedStatsFormat returns the stats format, the legacy format flag and the version, it is safe to call
+// concurrently with the collection.
+func (c *Ccache) negotiatedStatsFormat() (statsFormat, string, string) {
+	c.formatMux.Lock()
+	defer c.formatMux.Unlock()
+	return c.statsFormat, c.showStatsFlag, c.version
+}
+
+func (c *Ccache) toolName() string {
+	if c.Tool == "" {
+		return string(toolCcache)
+	}
+	return c.Tool
+}
+
+func (c *Ccache) parseToolVersion(bs []byte) (*semver.Version, error) {
+	if tool(c.Tool) == toolSccache {
+		return parseSccacheVersion(bs)
+	}
+	return parseVersion(bs)
+}
+
+func selectStatsFormat(ver *semver.Version, help []byte) statsFormat {
+	if len(help) > 0 {
+		switch {
+		case bytes.Contains(help, []byte("--format")) && bytes.Contains(help, []byte("json")):
+			return statsFormatJSON
+		case bytes.Contains(help, []byte("--print-stats")):
+			return statsFormatText
+		default:
+			return statsFormatLegacy
+		}
+	}
+
+	if ver.GTE(printStatsMinVer) {
+		return statsFormatText
+	}
+	return statsFormatLegacy
+}
+
+func selectShowStatsFlag(ver *semver.Version, help []byte) string {
+	if len(help) > 0 {
+		if bytes.Contains(help, []byte("--show-stats")) {
+			return "--show-stats"
+		}
+		return "-s"
+	}
+
+	if ver.GTE(showStatsLongMinVer) {
+		return "--show-stats"
+	}
+	return "-s"
+}
+
+func parseVersion(bs []byte) (*semver.Version, error) {
+	match := reVersion.FindSubmatch(bs)
+	if len(match) < 2 {
+		return nil, errors.New("can not find ccache version in '--version' output")
+	}
+
+	ver, err := semver.ParseTolerant(string(match[1]))
+	if err != nil {
+		return nil, fmt.Errorf("can not parse ccache version '%s': %v", match[1], err)
+	}
+
+	return &ver, nil
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// statProbeEntry is the entry the stat latency probe stats. It doesn't exist, so the probe has no side effects.
+const statProbeEntry = "netdata-stat-probe"
+
+// collectStatLatency reports the 'stat_latency_probe' measurement: the time to stat an absent entry in a cache
+// shard directory. It is the filesystem metadata latency of the cache directory, not a ccache lookup (ccache doesn't
+// report its lookup latency). A rising latency (a bloated cache dir, a slow network filesystem) slows the lookups down.
+func (c *Ccache) collectStatLatency(mx map[string]int64, _ map[string]int64) {
+	if !c.StatLatencyProbe {
+		return
+	}
+
+	d, err := c.probeStatLatency()
+	if err != nil {
+		c.Debugf("stat latency probe: %v", err)
+		return
+	}
+
+	// milliseconds multiplied by precision (microseconds)
+	mx["cache_dir_stat_latency_ms"] = d.Microseconds()
+	if !c.collectedStats["cache_dir_stat_latency_ms"] {
+		c.collectedStats["cache_dir_stat_latency_ms"] = true
+		c.addStatLatencyCharts()
+	}
+}
+
+// probeStatLatency times the stat of an absent entry, in a shard directory that changes every collection.
+// Like the stats files reads, the stat can't block the collection longer than 'timeout'.
+func (c *Ccache) probeStatLatency() (time.Duration, error) {
+	shard := cacheShards[c.statProbes%len(cacheShards)]
+	c.statProbes++
+	path := filepath.Join(c.cacheDir, shard, shard, statProbeEntry)
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.Timeout.Duration)
+	defer cancel()
+
+	ch := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		_, err := os.Stat(path)
+		ch <- err
+	}()
+
+	select {
+	case <-ctx.Done():
+		return 0, fmt.Errorf("stat '%s': %v", path, ctx.Err())
+	case err := <-ch:
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, err
+		}
+		return time.Since(start), nil
+	}
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/netdata/go.d.plugin/pkg/web"
+)
+
+// errStaleMirror is returned if the stats mirror is older than 'mirror_max_age'.
+var errStaleMirror = errors.New("stale stats mirror")
+
+func isMirrorURL(mirror string) bool {
+	return strings.HasPrefix(mirror, "http://") || strings.HasPrefix(mirror, "https://")
+}
+
+// initMirror sets up the 'mirror' source: a read-only copy of the stats replicated for the monitoring account,
+// so it doesn't need access to the cache itself.
+func (c *Ccache) initMirror() error {
+	if c.Mirror == "" {
+		return fmt.Errorf("'mirror' can not be empty with the '%s' source", sourceMirror)
+	}
+	if c.MirrorMaxAge.Duration < 0 {
+		return fmt.Errorf("'mirror_max_age' can not be negative (%s)", c.MirrorMaxAge.Duration)
+	}
+	if !isMirrorURL(c.Mirror) {
+		return nil
+	}
+
+	u, err := url.Parse(c.Mirror)
+	if err != nil {
+		return err
+	}
+	node, err := c.newCacheNode(u.Host, web.HTTP{
+		Request: web.Request{URL: c.Mirror, Username: c.Username, Password: c.Password},
+		Client:  web.Client{Timeout: c.Timeout},
+	})
+	if err != nil {
+		return err
+	}
+	c.mirrorNode = node
+	return nil
+}
+
+// queryMirror reads the stats mirror. Its age is the file modification time, or the 'Last-Modified' response header.
+// A mirror older than 'mirror_max_age' is rejected (the next source is tried), the stale stats would mislead; a mirror
+// of unknown age is rejected only if the freshness check is enabled.
+func (c *Ccache) queryMirror() (map[string]int64, error) {
+	bs, modTime, err := c.readMirror()
+	if err != nil {
+		return nil, err
+	}
+
+	c.hasMirrorAge = !modTime.IsZero()
+	c.mirrorAge = 0
+	if c.hasMirrorAge {
+		// the mirror host clock may be ahead
+		c.mirrorAge = max(0, time.Since(modTime))
+	}
+
+	if maxAge := c.MirrorMaxAge.Duration; maxAge > 0 {
+		if err := c.checkMirrorFreshness(maxAge); err != nil {
+			return nil, err
+		}
+	}
+
+	return parseStatsJSONOrText(bs)
+}
+
+func (c *Ccache) readMirror() ([]byte, time.Time, error) {
+	if c.mirrorNode != nil {
+		c.inflight.Add(1)
+		defer c.inflight.Done()
+
+		bs, header, err := c.mirrorNode.fetch(c.ctx)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		modTime, _ := http.ParseTime(header.Get("Last-Modified"))
+		return bs, modTime, nil
+	}
+
+	fi, err := os.Stat(c.Mirror)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	bs, err := os.ReadFile(c.Mirror)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if bs, err = maybeDecompress(bs); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %w", c.Mirror, err)
+	}
+	return bs, fi.ModTime(), nil
+}
+
+// checkMirrorFreshness rejects a stale mirror. The staleness is warned about once, until the mirror catches up.
+func (c *Ccache) checkMirrorFreshness(maxAge time.Duration) error {
+	var err error
+	switch {
+	case !c.hasMirrorAge:
+		err = fmt.Errorf("%w: mirror '%s' has no modification time (no 'Last-Modified' header), "+
+			"its freshness can't be checked", errStaleMirror, c.Mirror)
+	case c.mirrorAge > maxAge:
+		err = fmt.Errorf("%w: mirror '%s' was last updated %s ago ('mirror_max_age' is %s)",
+			errStaleMirror, c.Mirror, c.mirrorAge.Round(time.Second), maxAge)
+	}
+
+	if err == nil {
+		if c.mirrorStale {
+			c.Infof("mirror '%s' is up to date again", c.Mirror)
+		}
+		c.mirrorStale = false
+		return nil
+	}
+	if !c.mirrorStale {
+		c.Warning(err)
+	}
+	c.mirrorStale = true
+	return err
+}
+
+// collectMirrorAge reports the mirror lag, if the stats came from the mirror.
+func (c *Ccache) collectMirrorAge(mx map[string]int64) {
+	if c.activeSource != sourceMirror || !c.hasMirrorAge {
+		return
+	}
+
+	mx["mirror_age_seconds"] = int64(c.mirrorAge.Seconds())
+	if !c.collectedStats["mirror_age_seconds"] {
+		c.collectedStats["mirror_age_seconds"] = true
+		c.addMirrorAgeCharts()
+	}
+}
@@ -0,0 +1,193 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/netdata/go.d.plugin/pkg/web"
+)
+
+// NodeConfig is a remote stats source, an HTTP endpoint serving the ccache stats
+// in the '--print-stats' (text) or '--print-stats --format=json' (json) format.
+type NodeConfig struct {
+	Name     string `yaml:"name"`
+	web.HTTP `yaml:",inline"`
+}
+
+type cacheNode struct {
+	name       string
+	id         string // the name sanitized for the charts and dimensions IDs
+	req        web.Request
+	httpClient *http.Client
+}
+
+func (c *Ccache) initNodes() ([]*cacheNode, error) {
+	if len(c.Nodes) == 0 {
+		return nil, fmt.Errorf("'nodes' can not be empty in '%s' collection mode", collectionModeNodes)
+	}
+
+	var nodes []*cacheNode
+	seen := make(map[string]bool)
+
+	for i, cfg := range c.Nodes {
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("node #%d: 'url' can not be empty", i+1)
+		}
+		name := cfg.Name
+		if name == "" {
+			u, err := url.Parse(cfg.URL)
+			if err != nil {
+				return nil, fmt.Errorf("node #%d: %v", i+1, err)
+			}
+			name = u.Host
+		}
+		// the names are unique as IDs, 'a.b' and 'a_b' would share the charts
+		id := sanitizeID(name)
+		if seen[id] {
+			return nil, fmt.Errorf("node #%d: duplicate name '%s' (as '%s')", i+1, name, id)
+		}
+		seen[id] = true
+
+		node, err := c.newCacheNode(name, cfg.HTTP)
+		if err != nil {
+			return nil, fmt.Errorf("node '%s': %v", name, err)
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// initURLNode creates the 'url' collection mode stats source.
+func (c *Ccache) initURLNode() (*cacheNode, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("'url' can not be empty in '%s' collection mode", collectionModeURL)
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.newCacheNode(u.Host, web.HTTP{
+		Request: web.Request{URL: c.URL, Username: c.Username, Password: c.Password},
+		Client:  web.Client{Timeout: c.Timeout},
+	})
+}
+
+// newCacheNode creates a remote stats source, the job 'timeout' is used if the node has no own timeout.
+func (c *Ccache) newCacheNode(name string, cfg web.HTTP) (*cacheNode, error) {
+	if cfg.Timeout.Duration == 0 {
+		cfg.Timeout = c.Timeout
+	}
+	client, err := web.NewHTTPClient(cfg.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cacheNode{name: name, id: sanitizeID(name), req: cfg.Request.Copy(), httpClient: client}, nil
+}
+
+// queryNodesStats fetches the stats of all the nodes concurrently and sums their counters.
+// Unreachable nodes are logged and skipped.
+func (c *Ccache) queryNodesStats() (map[string]int64, error) {
+	type result struct {
+		node  *cacheNode
+		stats map[string]int64
+		err   error
+	}
+
+	results := make([]result, len(c.nodes))
+	var wg sync.WaitGroup
+
+	for i, node := range c.nodes {
+		wg.Add(1)
+		go func(i int, node *cacheNode) {
+			defer wg.Done()
+			stats, err := c.queryNode(node)
+			results[i] = result{node: node, stats: stats, err: err}
+		}(i, node)
+	}
+	wg.Wait()
+
+	stats := make(map[string]int64)
+	c.nodesStats = make(map[string]map[string]int64)
+
+	for _, res := range results {
+		if res.err != nil {
+			c.Warningf("node '%s': %v", res.node.name, res.err)
+			continue
+		}
+		// the nodes can run different ccache versions, their keys are normalized before they are summed
+		normalizeStatsKeys(res.stats)
+		c.nodesStats[res.node.name] = res.stats
+		for k, v := range res.stats {
+			stats[k] += v
+		}
+	}
+
+	if len(c.nodesStats) == 0 {
+		return nil, errors.New("all nodes failed")
+	}
+
+	return stats, nil
+}
+
+// queryNode fetches the node stats. The request is tracked so Cleanup() can cancel and drain it.
+func (c *Ccache) queryNode(node *cacheNode) (map[string]int64, error) {
+	c.inflight.Add(1)
+	defer c.inflight.Done()
+
+	return node.queryStats(c.ctx)
+}
+
+func (n *cacheNode) queryStats(ctx context.Context) (map[string]int64, error) {
+	bs, _, err := n.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseStatsJSONOrText(bs)
+}
+
+// fetch returns the (decompressed) response body and the response headers.
+func (n *cacheNode) fetch(ctx context.Context) ([]byte, http.Header, error) {
+	req, err := web.NewHTTPRequest(n.req)
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error on connecting to %s: %v", req.URL, err)
+	}
+	defer closeBody(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s returned %d status code (%s)", req.URL, resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error on reading response from %s : %v", req.URL, err)
+	}
+	if bs, err = maybeDecompress(bs); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", req.URL, err)
+	}
+
+	return bs, resp.Header, nil
+}
+
+func closeBody(resp *http.Response) {
+	if resp != nil && resp.Body != nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+}
@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/netdata/go.d.plugin/agent/module"
+)
+
+const (
+	openMetricsFunction    = "openmetrics"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	openMetricsPrefix      = "ccache_"
+)
+
+// openMetricsResult is the 'openmetrics' function response.
+type openMetricsResult struct {
+	ContentType string `json:"content_type"`
+	Metrics     string `json:"metrics"`
+}
+
+// metricsSnapshot is the last successful collection, as rendered by the 'openmetrics' function. It is taken by
+// Collect(): the function runs concurrently with the collection, it must not read the charts.
+type metricsSnapshot struct {
+	time    time.Time
+	metrics []snapshotMetric
+}
+
+type snapshotMetric struct {
+	id    string
+	help  string
+	typ   string
+	value float64
+}
+
+// takeMetricsSnapshot saves the collected metrics with their metadata from the charts: the incremental dimensions
+// are counters, the other ones gauges. The metrics not on any chart (e.g. the passthrough unknown keys) are 'unknown'.
+// The values are scaled by the dimensions multiplier and divisor (e.g. the percentages precision), the multiplier sign
+// is a rendering convention and is dropped.
+func (c *Ccache) takeMetricsSnapshot(mx map[string]int64) {
+	type chartDim struct {
+		chart *module.Chart
+		dim   *module.Dim
+	}
+	dims := make(map[string]chartDim)
+	for _, chart := range *c.Charts() {
+		for _, dim := range chart.Dims {
+			if _, ok := dims[dim.ID]; !ok && !dim.Obsolete {
+				dims[dim.ID] = chartDim{chart: chart, dim: dim}
+			}
+		}
+	}
+
+	snap := &metricsSnapshot{time: time.Now(), metrics: make([]snapshotMetric, 0, len(mx))}
+	for id, v := range mx {
+		m := snapshotMetric{id: id, typ: "unknown", value: float64(v)}
+		if d, ok := dims[id]; ok {
+			m.help = fmt.Sprintf("%s (%s)", d.chart.Title, d.dim.Name)
+			m.typ = "gauge"
+			if d.dim.Algo == module.Incremental {
+				m.typ = "counter"
+			}
+			if mul := d.dim.Mul; mul != 0 {
+				m.value *= float64(max(mul, -mul))
+			}
+			if div := d.dim.Div; div != 0 {
+				m.value /= float64(max(div, -div))
+			}
+		}
+		snap.metrics = append(snap.metrics, m)
+	}
+	sort.Slice(snap.metrics, func(i, j int) bool { return snap.metrics[i].id < snap.metrics[j].id })
+
+	c.snapshotMux.Lock()
+	c.snapshot = snap
+	c.snapshotMux.Unlock()
+}
+
+// runOpenMetricsFunction renders the last collection in the OpenMetrics text exposition format, to scrape the job state
+// directly while debugging. It is not a replacement for the agent export.
+func (c *Ccache) runOpenMetricsFunction() ([]byte, error) {
+	c.snapshotMux.Lock()
+	snap := c.snapshot
+	c.snapshotMux.Unlock()
+
+	if snap == nil {
+		return nil, errors.New("no metrics collected yet")
+	}
+
+	return json.Marshal(openMetricsResult{
+		ContentType: openMetricsContentType,
+		Metrics:     renderOpenMetrics(snap),
+	})
+}
+
+func renderOpenMetrics(snap *metricsSnapshot) string {
+	var sb strings.Builder
+	ts := strconv.FormatFloat(float64(snap.time.UnixMilli())/1000, 'f', -1, 64)
+
+	for _, m := range snap.metrics {
+		name := openMetricsName(m.id)
+		if m.help != "" {
+			fmt.Fprintf(&sb, "# HELP %s %s\n", name, escapeOpenMetricsHelp(m.help))
+		}
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", name, m.typ)
+
+		sample := name
+		if m.typ == "counter" {
+			sample += "_total"
+		}
+		fmt.Fprintf(&sb, "%s %s %s\n", sample, strconv.FormatFloat(m.value, 'g', -1, 64), ts)
+	}
+	sb.WriteString("# EOF\n")
+
+	return sb.String()
+}
+
+// openMetricsName returns the metric family name of the metric ID. The prefixed IDs (the nodes, the cache dirs) can
+// contain characters not allowed in the names, they are replaced with '_'.
+func openMetricsName(id string) string {
+	return openMetricsPrefix + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+func escapeOpenMetricsHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
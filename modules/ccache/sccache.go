@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/blang/semver/v4"
+)
+
+// tool is the compiler cache the stats are collected from ('tool').
+type tool string
+
+const (
+	toolCcache  tool = "ccache"
+	toolSccache tool = "sccache"
+)
+
+// statsFormatSccache is 'sccache --show-stats --stats-format=json' output.
+const statsFormatSccache statsFormat = "sccache-json"
+
+var reSccacheVersion = regexp.MustCompile(`sccache (\d+\.\d+(?:\.\d+)?)`)
+
+func parseSccacheVersion(bs []byte) (*semver.Version, error) {
+	match := reSccacheVersion.FindSubmatch(bs)
+	if len(match) < 2 {
+		return nil, errors.New("can not find sccache version in '--version' output")
+	}
+
+	ver, err := semver.ParseTolerant(string(match[1]))
+	if err != nil {
+		return nil, fmt.Errorf("can not parse sccache version '%s': %v", match[1], err)
+	}
+
+	return &ver, nil
+}
+
+// sccacheStatsKeys are the sccache stats that map 1:1 to the ccache stats keys.
+// sccache hashes the preprocessed source (C/C++), so its hits are preprocessed mode hits.
+var sccacheStatsKeys = map[string]string{
+	"cache_hits":      "preprocessed_cache_hit",
+	"cache_misses":    "cache_miss",
+	"compile_fails":   "compile_failed",
+	"forced_recaches": "recache",
+}
+
+// parseStatsSccache parses 'sccache --show-stats --stats-format=json' output. The stats that overlap with ccache
+// are mapped to the ccache keys, the other (sccache specific) counters are reported as 'sccache_<name>' keys
+// ('unknown_key_policy' applies to them). Per language counters ({"counts": {"C/C++": 10}}) are summed,
+// durations are not collected.
+func parseStatsSccache(bs []byte) (map[string]int64, error) {
+	var raw struct {
+		Stats     map[string]json.RawMessage `json:"stats"`
+		CacheSize *int64                     `json:"cache_size"`
+	}
+	if err := json.Unmarshal(bs, &raw); err != nil {
+		return nil, fmt.Errorf("unmarshal sccache stats json: %v", err)
+	}
+	if raw.Stats == nil {
+		return nil, errors.New("no 'stats' object in sccache stats json")
+	}
+
+	stats := make(map[string]int64)
+
+	for name, msg := range raw.Stats {
+		v, ok := sccacheCounter(msg)
+		if !ok {
+			continue
+		}
+		if key, ok := sccacheStatsKeys[name]; ok {
+			stats[key] = v
+		} else {
+			stats["sccache_"+name] = v
+		}
+	}
+	if raw.CacheSize != nil {
+		stats["cache_size_bytes"] = *raw.CacheSize
+	}
+
+	return stats, nil
+}
+
+// sccacheCounter returns the value of a plain number or the sum of a per language counter.
+func sccacheCounter(msg json.RawMessage) (int64, bool) {
+	var n int64
+	if err := json.Unmarshal(msg, &n); err == nil {
+		return n, true
+	}
+
+	var pl struct {
+		Counts map[string]int64 `json:"counts"`
+	}
+	if err := json.Unmarshal(msg, &pl); err != nil || pl.Counts == nil {
+		return 0, false
+	}
+	var sum int64
+	for _, v := range pl.Counts {
+		sum += v
+	}
+	return sum, true
+}
@@ -0,0 +1,41 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"sort"
+)
+
+// selfTest compares the stats reported by ccache with the stats read from the cache stats files
+// and logs (and returns) the differing keys, to validate the 'file' collection mode parser against the authoritative ccache output.
+func (c *Ccache) selfTest(execStats map[string]int64) []string {
+	fileStats, err := c.queryStatsFiles()
+	if err != nil {
+		c.Warningf("self test: %v", err)
+		return nil
+	}
+
+	var keys []string
+	for _, key := range statsFileCounters {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var diffs []string
+	for _, key := range keys {
+		ev, ok1 := execStats[key]
+		fv, ok2 := fileStats[key]
+		if !ok1 || !ok2 || ev == fv {
+			continue
+		}
+		diffs = append(diffs, key)
+		c.Warningf("self test: '%s' differs: exec %d, stats files %d", key, ev, fv)
+	}
+
+	if len(diffs) == 0 {
+		c.Debugf("self test: exec and stats files results match")
+	}
+	return diffs
+}
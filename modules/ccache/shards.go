@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"context"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ccache spreads the cache files across 16 top-level shard directories ('0'-'f').
+var cacheShards = []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "a", "b", "c", "d", "e", "f"}
+
+func (c *Ccache) collectShardBalance(mx map[string]int64) {
+	now := time.Now()
+	if c.shardBalance == nil || now.Sub(c.shardBalanceTime) >= c.shardBalanceEvery {
+		// the directory walk is expensive, it is done at most once per 'shardBalanceEvery'
+		c.shardBalanceTime = now
+		ctx, cancel := context.WithTimeout(c.ctx, c.Timeout.Duration)
+		counts, err := countShardsFiles(ctx, c.cacheDir)
+		cancel()
+		if err != nil {
+			c.Warningf("count '%s' shards files: %v", c.cacheDir, err)
+			return
+		}
+		c.shardBalance = shardBalanceMetrics(counts)
+	}
+
+	for k, v := range c.shardBalance {
+		mx[k] = v
+	}
+}
+
+func countShardsFiles(ctx context.Context, cacheDir string) ([]int64, error) {
+	counts := make([]int64, len(cacheShards))
+
+	for i, shard := range cacheShards {
+		err := filepath.WalkDir(filepath.Join(cacheDir, shard), func(path string, d fs.DirEntry, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if d.Type().IsRegular() && d.Name() != "stats" {
+				counts[i]++
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return counts, nil
+}
+
+func shardBalanceMetrics(counts []int64) map[string]int64 {
+	minV, maxV, sum := counts[0], counts[0], int64(0)
+	for _, v := range counts {
+		minV, maxV, sum = min(minV, v), max(maxV, v), sum+v
+	}
+
+	mean := float64(sum) / float64(len(counts))
+	var variance float64
+	for _, v := range counts {
+		variance += (float64(v) - mean) * (float64(v) - mean)
+	}
+	variance /= float64(len(counts))
+
+	return map[string]int64{
+		"shard_files_min":    minV,
+		"shard_files_max":    maxV,
+		"shard_files_stddev": int64(math.Sqrt(variance) * precision),
+	}
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// resolveSloppiness returns the enabled ccache 'sloppiness' flags (sorted). They relax the hash checks and explain
+// hit rates that differ between otherwise identical hosts. It is resolved once, the config doesn't change while
+// the builds run.
+func (c *Ccache) resolveSloppiness() ([]string, error) {
+	if tool(c.Tool) == toolSccache {
+		return nil, errors.New("sccache has no sloppiness")
+	}
+
+	bs, err := c.exec.printConfig()
+	if err != nil {
+		return nil, err
+	}
+	flags, ok := parseSloppiness(bs)
+	if !ok {
+		return nil, errors.New("no 'sloppiness' in the config output")
+	}
+	return flags, nil
+}
+
+// parseSloppiness parses the 'sloppiness' line of the '-p' output ('(origin) sloppiness = flag, flag').
+// The flags are comma (or space) separated, ccache accepts both.
+func parseSloppiness(bs []byte) ([]string, bool) {
+	sc := bufio.NewScanner(bytes.NewReader(bs))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "(") {
+			if i := strings.Index(line, ") "); i > 0 {
+				line = line[i+2:]
+			}
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) != "sloppiness" {
+			continue
+		}
+
+		seen := make(map[string]bool)
+		flags := []string{}
+		for _, flag := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			if !seen[flag] {
+				seen[flag] = true
+				flags = append(flags, flag)
+			}
+		}
+		sort.Strings(flags)
+		return flags, true
+	}
+	return nil, false
+}
+
+// collectSloppiness reports the number of the enabled sloppiness flags, if they were resolved.
+func (c *Ccache) collectSloppiness(mx map[string]int64) {
+	if c.sloppiness == nil {
+		return
+	}
+	mx["sloppiness_flags"] = int64(len(c.sloppiness))
+}
@@ -0,0 +1,194 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	sourceJSONExec   = "json-exec"
+	sourceTextExec   = "text-exec"
+	sourceLegacyExec = "legacy-exec"
+	sourceStatsFile  = "statsfile"
+	sourceNodes      = "nodes"
+	sourceURL        = "url"
+	sourceMirror     = "mirror"
+)
+
+var knownSources = []string{sourceJSONExec, sourceTextExec, sourceLegacyExec, sourceStatsFile, sourceNodes, sourceURL,
+	sourceMirror}
+
+// statsSource is a collection strategy. In 'sources' collection mode the sources are tried in the configured order
+// until one of them yields stats.
+type statsSource interface {
+	name() string
+	queryStats() (map[string]int64, error)
+}
+
+type execSource struct {
+	c      *Ccache
+	format statsFormat
+}
+
+func (s execSource) name() string { return string(s.format) + "-exec" }
+
+func (s execSource) queryStats() (map[string]int64, error) {
+	bs, parse, err := s.c.execStats(s.format)
+	if err != nil {
+		return nil, err
+	}
+	s.c.debugRawOutput(bs)
+
+	return s.c.parseExecStats(s.format, bs, parse)
+}
+
+type statsFilesSource struct{ c *Ccache }
+
+func (s statsFilesSource) name() string { return sourceStatsFile }
+
+func (s statsFilesSource) queryStats() (map[string]int64, error) { return s.c.queryStatsFiles() }
+
+type nodesSource struct{ c *Ccache }
+
+func (s nodesSource) name() string { return sourceNodes }
+
+func (s nodesSource) queryStats() (map[string]int64, error) { return s.c.queryNodesStats() }
+
+type urlSource struct{ c *Ccache }
+
+func (s urlSource) name() string { return sourceURL }
+
+func (s urlSource) queryStats() (map[string]int64, error) { return s.c.queryNode(s.c.urlNode) }
+
+type mirrorSource struct{ c *Ccache }
+
+func (s mirrorSource) name() string { return sourceMirror }
+
+func (s mirrorSource) queryStats() (map[string]int64, error) { return s.c.queryMirror() }
+
+func isExecSource(name string) bool {
+	return name == sourceJSONExec || name == sourceTextExec || name == sourceLegacyExec
+}
+
+func validateSources(sources []string) error {
+	if len(sources) == 0 {
+		return fmt.Errorf("'sources' can not be empty in '%s' collection mode", collectionModeSources)
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range sources {
+		if !slices.Contains(knownSources, name) {
+			return fmt.Errorf("unknown source '%s' (supported: %v)", name, knownSources)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate source '%s'", name)
+		}
+		seen[name] = true
+	}
+
+	return nil
+}
+
+// initSources creates the configured sources and initializes what they depend on: the ccache binary
+// (exec sources), the nodes (nodes source), the url node (url source) and the mirror (mirror source). The cache directory (statsfile source) is resolved in Init().
+func (c *Ccache) initSources() ([]statsSource, error) {
+	var sources []statsSource
+
+	for _, name := range c.Sources {
+		if isExecSource(name) {
+			if err := c.initSourcesExec(); err != nil {
+				return nil, err
+			}
+		}
+
+		switch name {
+		case sourceJSONExec:
+			sources = append(sources, execSource{c: c, format: statsFormatJSON})
+		case sourceTextExec:
+			sources = append(sources, execSource{c: c, format: statsFormatText})
+		case sourceLegacyExec:
+			sources = append(sources, execSource{c: c, format: statsFormatLegacy})
+		case sourceStatsFile:
+			sources = append(sources, statsFilesSource{c: c})
+		case sourceNodes:
+			nodes, err := c.initNodes()
+			if err != nil {
+				return nil, fmt.Errorf("init nodes: %v", err)
+			}
+			c.nodes = nodes
+			sources = append(sources, nodesSource{c: c})
+		case sourceURL:
+			node, err := c.initURLNode()
+			if err != nil {
+				return nil, fmt.Errorf("init url: %v", err)
+			}
+			c.urlNode = node
+			sources = append(sources, urlSource{c: c})
+		case sourceMirror:
+			if err := c.initMirror(); err != nil {
+				return nil, fmt.Errorf("init mirror: %v", err)
+			}
+			sources = append(sources, mirrorSource{c: c})
+		}
+	}
+
+	return sources, nil
+}
+
+// initSourcesExec looks up the ccache binary and probes its version (and the legacy stats flag) once.
+func (c *Ccache) initSourcesExec() error {
+	if c.version != "" {
+		return nil
+	}
+	if c.exec == nil {
+		ce, err := c.initCcacheExec()
+		if err != nil {
+			return fmt.Errorf("init ccache exec: %v", err)
+		}
+		c.exec = ce
+	}
+
+	f, err := c.negotiateStatsFormat()
+	if err != nil {
+		return fmt.Errorf("negotiate stats format: %w", err)
+	}
+	c.setStatsFormat(f)
+	c.versionCheckTime = time.Now()
+
+	return nil
+}
+
+func (c *Ccache) hasSource(name string) bool {
+	return collectionMode(c.CollectionMode) == collectionModeSources && slices.Contains(c.Sources, name)
+}
+
+// querySources returns the stats of the first source that yields data.
+func (c *Ccache) querySources() (map[string]int64, error) {
+	var errs []error
+
+	for _, src := range c.sources {
+		stats, err := src.queryStats()
+		if err == nil && len(stats) == 0 {
+			err = errors.New("no stats found")
+		}
+		if err != nil {
+			c.logger(err).Debugf("source '%s': %v", src.name(), err)
+			errs = append(errs, fmt.Errorf("source '%s': %w", src.name(), err))
+			continue
+		}
+
+		if c.activeSource != src.name() {
+			c.Infof("collecting stats from '%s' source", src.name())
+			c.activeSource = src.name()
+		}
+		return stats, nil
+	}
+
+	c.activeSource = ""
+
+	return nil, fmt.Errorf("all sources failed: %w", errors.Join(errs...))
+}
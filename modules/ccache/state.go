@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// stateFileMaxSize caps the 'state_file', a bigger file is not ours (or is corrupt) and is ignored.
+	stateFileMaxSize = 1024 * 1024
+	// stateMaxAge is how old the persisted samples can be to be restored, the older ones are dropped.
+	stateMaxAge  = growthSamples * growthSampleEvery
+	stateVersion = 1
+)
+
+// persistedState is the 'state_file' content: the samples the windowed derived metrics (the recent hit ratio and
+// trend, the cache growth rate) need, so they continue across agent restarts instead of starting over.
+type persistedState struct {
+	Version int `json:"version"`
+	// Timestamp is when the counters snapshot was taken.
+	Timestamp             int64                 `json:"timestamp"`
+	Counters              map[string]int64      `json:"counters"`
+	PrevRecentHitRatio    int64                 `json:"prev_recent_hit_ratio"`
+	HasPrevRecentHitRatio bool                  `json:"has_prev_recent_hit_ratio"`
+	SizeSamples           []persistedSizeSample `json:"size_samples"`
+}
+
+type persistedSizeSample struct {
+	Timestamp int64 `json:"timestamp"`
+	Size      int64 `json:"size"`
+}
+
+// loadState restores the persisted state. A missing, corrupt or outdated state file is not an error,
+// the job starts fresh.
+func (c *Ccache) loadState() {
+	st, err := readStateFile(c.StateFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			c.Infof("state file '%s' doesn't exist, starting fresh", c.StateFile)
+		} else {
+			c.Warningf("state file '%s': %v, starting fresh", c.StateFile, err)
+		}
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(time.Unix(st.Timestamp, 0)) <= stateMaxAge && len(st.Counters) > 0 {
+		c.prevStats = st.Counters
+		c.prevRecentHitRatio, c.hasPrevRecentHitRatio = st.PrevRecentHitRatio, st.HasPrevRecentHitRatio
+	}
+	for _, s := range st.SizeSamples {
+		if t := time.Unix(s.Timestamp, 0); now.Sub(t) <= stateMaxAge {
+			c.sizeSamples = append(c.sizeSamples, sizeSample{time: t, size: s.Size})
+		}
+	}
+	if len(c.sizeSamples) > growthSamples {
+		c.sizeSamples = c.sizeSamples[len(c.sizeSamples)-growthSamples:]
+	}
+	c.cacheGrowth, c.hasCacheGrowth = fitGrowthRate(c.sizeSamples)
+
+	c.Debugf("restored the state from '%s' (%d size samples)", c.StateFile, len(c.sizeSamples))
+}
+
+func readStateFile(path string) (*persistedState, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > stateFileMaxSize {
+		return nil, fmt.Errorf("too big (%d bytes, max %d)", fi.Size(), stateFileMaxSize)
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var st persistedState
+	if err := json.Unmarshal(bs, &st); err != nil {
+		return nil, fmt.Errorf("corrupt: %v", err)
+	}
+	if st.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported version %d", st.Version)
+	}
+	return &st, nil
+}
+
+// saveState persists the state after a successful collection. Write errors don't fail the collection, they are
+// logged once until a write succeeds again.
+func (c *Ccache) saveState() {
+	if err := c.writeState(); err != nil {
+		if !c.stateFailed {
+			c.Warningf("save state to '%s': %v", c.StateFile, err)
+		}
+		c.stateFailed = true
+		return
+	}
+	c.stateFailed = false
+}
+
+// writeState writes the state file atomically (a temporary file renamed over it), a crash never leaves it torn.
+func (c *Ccache) writeState() error {
+	st := persistedState{
+		Version:               stateVersion,
+		Timestamp:             time.Now().Unix(),
+		Counters:              c.prevStats,
+		PrevRecentHitRatio:    c.prevRecentHitRatio,
+		HasPrevRecentHitRatio: c.hasPrevRecentHitRatio,
+	}
+	for _, s := range c.sizeSamples {
+		st.SizeSamples = append(st.SizeSamples, persistedSizeSample{Timestamp: s.time.Unix(), Size: s.size})
+	}
+
+	bs, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(c.StateFile), filepath.Base(c.StateFile)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if _, err := f.Write(bs); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.StateFile)
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"github.com/netdata/go.d.plugin/agent/module"
+)
+
+// chartsTemplates are the charts the collector can add, it is the 'suppress_metrics' known metrics source.
+// The per node and per cache dir charts are not included, their dimensions IDs are prefixed.
+var chartsTemplates = []*module.Chart{
+	&hitsChart, &missesChart, &missesByModeChart, &totalCallsChart, &hitRatioChart, &cacheableCallShareChart,
+	&preprocessingCallShareChart, &storeRetrieveRatioChart, &recentHitRatioChart, &cacheEffectiveChart,
+	&hitRateTrendChart, &recentMissReasonsChart, &uncacheableCallsChart, &unsupportedOptionsChart, &errorsChart,
+	&sinceStartCallsChart, &sinceStartHitRatioChart, &localStorageChart, &remoteStorageChart, &remoteStorageErrorsChart,
+	&remoteStorageTimeoutShareChart, &cacheSizeChart, &cacheGrowthChart, &filesInCacheChart, &cacheChurnChart,
+	&avgObjectSizeChart, &estimatedIOSavedChart, &cleanupsChart, &evictionPressureChart, &metricsAgeChart,
+	&mirrorAgeChart, &collectionHealthChart, &collectionStreaksChart, &lastCleanupChart, &shardBalanceChart,
+	&fileCountDiscrepancyChart, &statsFilesChart, &estimatedTimeSavedChart, &callsPerBuildChart, &statLatencyChart,
+	&sloppinessChart, &versionMatchesExpectedChart,
+}
+
+// knownMetrics are the metrics IDs the collector reports: the charts dimensions and the uncacheable calls and errors
+// (the dynamic dimensions, the recent ones are prefixed with 'recent_').
+var knownMetrics = func() map[string]bool {
+	known := make(map[string]bool)
+	for _, chart := range chartsTemplates {
+		for _, dim := range chart.Dims {
+			known[dim.ID] = true
+		}
+	}
+	for _, keys := range [][]string{uncacheableCallsStats, errorsStats} {
+		for _, key := range keys {
+			known[key] = true
+			known["recent_"+key] = true
+		}
+	}
+	return known
+}()
+
+// initSuppressedMetrics sets up 'suppress_metrics'. The unknown entries are kept (they may match a metric of
+// a newer ccache or a remote source), but they are likely typos and are warned about.
+func (c *Ccache) initSuppressedMetrics() {
+	if len(c.SuppressMetrics) == 0 {
+		return
+	}
+	c.suppressedMetrics = make(map[string]bool)
+	for _, id := range c.SuppressMetrics {
+		if !knownMetrics[id] {
+			c.Warningf("'suppress_metrics': unknown metric '%s'", id)
+		}
+		c.suppressedMetrics[id] = true
+	}
+}
+
+// suppressDims removes the suppressed dimensions from the chart. It returns false if the chart had dimensions and all
+// of them are suppressed: the chart is not added.
+func (c *Ccache) suppressDims(chart *module.Chart) bool {
+	if len(c.suppressedMetrics) == 0 || len(chart.Dims) == 0 {
+		return true
+	}
+	dims := chart.Dims[:0]
+	for _, dim := range chart.Dims {
+		if !c.suppressedMetrics[dim.ID] {
+			dims = append(dims, dim)
+		}
+	}
+	chart.Dims = dims
+	return len(chart.Dims) > 0
+}
+
+// suppressMetrics removes the suppressed metrics from the collected ones. It is applied last: the derived metrics
+// are computed from all the values, including the suppressed ones.
+func (c *Ccache) suppressMetrics(mx map[string]int64) {
+	for id := range c.suppressedMetrics {
+		delete(mx, id)
+	}
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"fmt"
+	"sort"
+)
+
+// unknownKeyPolicy is how the stats keys the collector doesn't recognize are handled ('unknown_key_policy').
+type unknownKeyPolicy string
+
+const (
+	unknownKeyPolicyIgnore      unknownKeyPolicy = "ignore"
+	unknownKeyPolicyWarn        unknownKeyPolicy = "warn"
+	unknownKeyPolicyPassthrough unknownKeyPolicy = "passthrough"
+)
+
+func validateUnknownKeyPolicy(policy string) error {
+	switch unknownKeyPolicy(policy) {
+	case unknownKeyPolicyIgnore, unknownKeyPolicyWarn, unknownKeyPolicyPassthrough:
+		return nil
+	default:
+		return fmt.Errorf("unknown 'unknown_key_policy' '%s' (supported: '%s', '%s', '%s')",
+			policy, unknownKeyPolicyIgnore, unknownKeyPolicyWarn, unknownKeyPolicyPassthrough)
+	}
+}
+
+// otherStats are the recognized stats keys that are neither uncacheable calls nor errors.
+var otherStats = []string{
+	"stats_updated_timestamp",
+	"stats_zeroed_timestamp",
+	"direct_cache_hit",
+	"direct_cache_miss",
+	"preprocessed_cache_hit",
+	"preprocessed_cache_miss",
+	"cache_miss",
+	"files_in_cache",
+	"cleanups_performed",
+	"local_storage_hit",
+	"local_storage_miss",
+	"local_storage_read_hit",
+	"local_storage_read_miss",
+	"local_storage_write",
+	"remote_storage_error",
+	"remote_storage_hit",
+	"remote_storage_miss",
+	"remote_storage_read_hit",
+	"remote_storage_read_miss",
+	"remote_storage_timeout",
+	"remote_storage_write",
+}
+
+// knownStatsKeys are all the stats keys the collector recognizes.
+var knownStatsKeys = func() map[string]bool {
+	known := make(map[string]bool)
+	for _, keys := range [][]string{uncacheableCallsStats, errorsStats, otherStats} {
+		for _, key := range keys {
+			known[key] = true
+		}
+	}
+	for _, v := range cacheSizeKeys {
+		known[v.key] = true
+	}
+	return known
+}()
+
+// collectUnknownStats applies 'unknown_key_policy' to the stats keys the collector doesn't recognize.
+func (c *Ccache) collectUnknownStats(mx map[string]int64, stats map[string]int64) {
+	policy := unknownKeyPolicy(c.UnknownKeyPolicy)
+	if policy == "" || policy == unknownKeyPolicyIgnore {
+		return
+	}
+
+	unknown := make(map[string]int64)
+	for key, v := range stats {
+		if !knownStatsKeys[key] && c.statsSections[key] == "" {
+			unknown[key] = v
+		}
+	}
+	if len(unknown) == 0 {
+		return
+	}
+
+	switch policy {
+	case unknownKeyPolicyWarn:
+		keys := make([]string, 0, len(unknown))
+		for key := range unknown {
+			if !c.warnedUnknownKeys[key] {
+				c.warnedUnknownKeys[key] = true
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			c.Warningf("unknown stats key '%s', it is not collected", key)
+		}
+	case unknownKeyPolicyPassthrough:
+		// 'passthrough_all_keys' already reports them
+		if !c.PassthroughAllKeys {
+			c.collectRawStats(mx, unknown)
+		}
+	}
+}
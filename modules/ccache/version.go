@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ccache
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+var reExpectedVersion = regexp.MustCompile(`^\d+(\.\d+){0,2}$`)
+
+// versionMatches reports whether the version is the expected one: the same version, or one of the series of
+// a shorter expected version ('4.8' matches '4.8.3', it doesn't match '4.10.2').
+func versionMatches(version, expected string) bool {
+	return version == expected || strings.HasPrefix(version, expected+".")
+}
+
+// checkExpectedVersion compares the detected version with 'expected_version'. With environment modules the binary
+// found in PATH can change per shell, a mismatch means the metrics come from another ccache than the one expected.
+// The mismatch is warned about once per detected version.
+func (c *Ccache) checkExpectedVersion() {
+	c.versionMatchesExpected = versionMatches(c.version, c.ExpectedVersion)
+	if c.versionMatchesExpected {
+		c.mismatchedVersion = ""
+		return
+	}
+	if c.mismatchedVersion != c.version {
+		c.Warningf("%s '%s' version is %s, expected %s: the metrics are not collected from the expected %s, "+
+			"set 'binary_path' to the expected binary", c.toolName(), c.BinaryPath, c.version, c.ExpectedVersion, c.toolName())
+		c.mismatchedVersion = c.version
+	}
+}
+
+// checkVersion re-probes the ccache version (at most once per 'versionCheckEvery') to detect upgrades.
+// An upgrade can change the cache format: the entries of the previous version are not reused and the hit ratio drops.
+func (c *Ccache) checkVersion() {
+	if c.exec == nil || c.version == "" || time.Since(c.versionCheckTime) < c.versionCheckEvery {
+		return
+	}
+	c.versionCheckTime = time.Now()
+
+	bs, err := c.exec.version()
+	if err != nil {
+		c.logger(err).Debugf("exec %s --version: %v", c.toolName(), err)
+		return
+	}
+	ver, err := c.parseToolVersion(bs)
+	if err != nil {
+		c.Debug(err)
+		return
+	}
+	if ver.String() == c.version {
+		return
+	}
+
+	c.Warningf("%s version changed (%s => %s): the cache format may have changed, "+
+		"the entries cached by the previous version may not be reused and the hit ratio may drop", c.toolName(), c.version, ver)
+
+	f, err := c.negotiateStatsFormat()
+	if c.ExpectedVersion != "" {
+		c.checkExpectedVersion()
+	}
+	if err != nil {
+		c.logger(err).Warningf("negotiate stats format: %v", err)
+		return
+	}
+	if f != c.statsFormat {
+		c.Infof("using '%s' stats format (was '%s')", f, c.statsFormat)
+		c.setStatsFormat(f)
+	}
+}